@@ -2,4 +2,9 @@ module exercise03
 
 go 1.24.2
 
-require github.com/google/uuid v1.6.0
+require (
+	exercise05 v0.0.0
+	github.com/google/uuid v1.6.0
+)
+
+replace exercise05 => ../exercise05
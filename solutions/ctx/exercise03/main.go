@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+
+	"exercise05"
 
 	"github.com/google/uuid"
 )
@@ -52,7 +56,17 @@ func finalHandler(w http.ResponseWriter, r *http.Request) {
 
 func main() {
 	final := http.HandlerFunc(finalHandler)
-	handler := withRequestID(final)
+	// 每个已知的 API key 每秒 10 个请求，允许 20 个突发；没有 key 或 key 未知时按客户端 IP 限流，
+	// 客户端不能靠随意伪造 key 绕过限额。已知的 key 从环境变量 API_KEYS 读取，以逗号分隔。
+	keys := make(map[string]bool)
+	for _, k := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys[k] = true
+		}
+	}
+	keyFunc := exercise05.KeyByAPIKey("X-API-Key", func(k string) bool { return keys[k] })
+	limiter := exercise05.NewHTTPRateLimiter(10, 20, keyFunc)
+	handler := withRequestID(limiter.Handler(final))
 
 	mux := http.NewServeMux()
 	mux.Handle("/", handler)
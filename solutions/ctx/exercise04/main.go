@@ -2,8 +2,9 @@ package exercise04
 
 import (
 	"context"
-	"fmt"
+	"reflect"
 	"sync"
+	"sync/atomic"
 )
 
 /*
@@ -21,44 +22,209 @@ select 与 ctx.Done()：如何在 channel 操作中处理取消信号，实现
 Goroutine 生命周期管理：如何确保在函数返回后，没有 goroutine 泄漏。
 */
 
-func Merge(ctx context.Context, sources ...<-chan int) <-chan int {
-	var (
-		capacity int
-		wg       sync.WaitGroup
-		res      chan int
-	)
-	for _, source := range sources {
-		wg.Add(1)
-		capacity += cap(source)
+// Fairness 决定多个 source 同时有数据时 Merge 从哪个 source 取值
+type Fairness int
+
+const (
+	// Unordered 每个 source 一个 goroutine 直接竞争输出 channel，吞吐最高但不保证公平
+	Unordered Fairness = iota
+	// RoundRobin 轮流从每个有数据的 source 取一个值，数据多的 source 不会挤占其他 source
+	RoundRobin
+	// Weighted 与 RoundRobin 相同，但每轮从第 i 个 source 最多取 Weights[i] 个值
+	Weighted
+)
+
+// MergeOptions 是 Merge 的配置，零值表示无缓冲、Unordered、不记录日志
+type MergeOptions struct {
+	Buffer   int      // 输出 channel 的缓冲大小
+	Fairness Fairness // 多个 source 之间的公平策略
+	Weights  []int    // Weighted 模式下每个 source 的权重，缺省或不大于 0 时为 1
+
+	// Logf 用于输出 Merge 内部的日志，为 nil 时不输出
+	Logf func(format string, args ...any)
+	// OnDropped 在 ctx 取消导致合并提前结束时调用一次，dropped[i] 是从第 i 个 source 读出但因取消没有发送出去的值的数量。
+	// 所有 source 都已读完后 ctx 才取消不算提前结束，不会调用。
+	// Merge 结束后不再读取 source，还留在 source 里的值仍归调用方所有，不计入 dropped。
+	OnDropped func(dropped []int)
+}
+
+// Merge 把多个 source 合并到一个输出 channel。所有 source 关闭且数据都发送完毕后关闭输出 channel；
+// ctx 取消后停止合并，内部 goroutine 全部退出后关闭输出 channel，并通过 OnDropped 报告丢弃的数量。
+func Merge[T any](ctx context.Context, opts MergeOptions, sources ...<-chan T) <-chan T {
+	m := &mergeRun[T]{
+		ctx:     ctx,
+		opts:    opts,
+		sources: sources,
+		out:     make(chan T, opts.Buffer),
+		dropped: make([]int, len(sources)),
+		held:    -1,
+	}
+	if opts.Fairness == Unordered {
+		m.unordered()
+	} else {
+		m.fair()
 	}
-	res = make(chan int, capacity)
+	return m.out
+}
+
+// mergeRun 保存一次 Merge 的状态。dropped 和 held 只由读 source 的 goroutine 修改，在 wg.Wait 之后才被读取。
+type mergeRun[T any] struct {
+	ctx     context.Context
+	opts    MergeOptions
+	sources []<-chan T
+	out     chan T
+	dropped []int
+	held    int // 调度 goroutine 因取消而没能发送的值所属的 source，-1 表示没有
+	// canceled 表示有 goroutine 因 ctx 取消而提前退出；ctx 在所有 source 正常读完之后才取消时为 false
+	canceled atomic.Bool
+	wg       sync.WaitGroup
+}
 
-	for _, source := range sources {
-		go func(source <-chan int) {
-			defer wg.Done()
+// unordered 为每个 source 启动一个 goroutine，直接把值发送到输出 channel
+func (m *mergeRun[T]) unordered() {
+	for i, source := range m.sources {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
 			for {
 				select {
-				case <-ctx.Done():
-					fmt.Println("ctx canceled, exist")
+				case <-m.ctx.Done():
+					m.canceled.Store(true)
 					return
 				case v, ok := <-source:
 					if !ok {
 						return
 					}
 					select {
-					case <-ctx.Done():
+					case <-m.ctx.Done():
+						m.dropped[i]++
+						m.canceled.Store(true)
 						return
-					case res <- v:
+					case m.out <- v:
 					}
 				}
 			}
-		}(source)
+		}()
+	}
+	go m.finish()
+}
+
+// fair 用一个调度 goroutine 按权重轮流从每个 source 不阻塞地取值发送；
+// 所有 source 暂时都没有数据时，阻塞等待任意一个 source 或取消。
+func (m *mergeRun[T]) fair() {
+	weights := make([]int, len(m.sources))
+	for i := range weights {
+		weights[i] = 1
+		if m.opts.Fairness == Weighted && i < len(m.opts.Weights) && m.opts.Weights[i] > 0 {
+			weights[i] = m.opts.Weights[i]
+		}
 	}
 
+	m.wg.Add(1)
 	go func() {
-		wg.Wait()
-		close(res)
+		defer m.wg.Done()
+		closed := make([]bool, len(m.sources))
+		open := len(m.sources)
+		defer func() {
+			// 还有 source 没读完就退出，说明是被取消的
+			if open > 0 {
+				m.canceled.Store(true)
+			}
+		}()
+		send := func(i int, v T) bool {
+			select {
+			case <-m.ctx.Done():
+				m.held = i
+				return false
+			case m.out <- v:
+				return true
+			}
+		}
+
+		for open > 0 && m.ctx.Err() == nil {
+			progressed := false
+			for i, source := range m.sources {
+				for taken := 0; !closed[i] && taken < weights[i]; taken++ {
+					v, ok, ready := tryRecv(source)
+					if !ready {
+						break // 这个 source 暂时没有数据，轮到下一个
+					}
+					progressed = true
+					if !ok {
+						closed[i] = true
+						open--
+						break
+					}
+					if !send(i, v) {
+						return
+					}
+				}
+			}
+			if progressed {
+				continue
+			}
+
+			i, v, ok := m.wait(closed)
+			switch {
+			case i < 0:
+				return
+			case !ok:
+				closed[i] = true
+				open--
+			case !send(i, v):
+				return
+			}
+		}
 	}()
 
-	return res
+	go m.finish()
+}
+
+// wait 阻塞到任意一个没有关闭的 source 有数据或关闭，返回它的下标；ctx 取消时返回 -1
+func (m *mergeRun[T]) wait(closed []bool) (int, T, bool) {
+	cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.ctx.Done())}}
+	index := []int{-1}
+	for i, source := range m.sources {
+		if !closed[i] {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(source)})
+			index = append(index, i)
+		}
+	}
+	chosen, recv, ok := reflect.Select(cases)
+	var v T
+	if chosen == 0 {
+		return -1, v, false
+	}
+	if ok {
+		v = recv.Interface().(T)
+	}
+	return index[chosen], v, ok
+}
+
+// finish 等待所有 goroutine 退出后报告丢弃的值并关闭输出 channel。
+// 只有 goroutine 确实因取消提前退出时才报告，合并正常结束后 ctx 才被取消不算丢弃。
+func (m *mergeRun[T]) finish() {
+	m.wg.Wait()
+	if m.canceled.Load() {
+		if m.held >= 0 {
+			m.dropped[m.held]++
+		}
+		if m.opts.Logf != nil {
+			m.opts.Logf("merge canceled: %v, dropped per source %v", m.ctx.Err(), m.dropped)
+		}
+		if m.opts.OnDropped != nil {
+			m.opts.OnDropped(m.dropped)
+		}
+	}
+	close(m.out)
+}
+
+// tryRecv 不阻塞地从 ch 接收一个值，ready 为 false 表示 ch 中暂时没有数据
+func tryRecv[T any](ch <-chan T) (v T, ok, ready bool) {
+	select {
+	case v, ok = <-ch:
+		return v, ok, true
+	default:
+		return v, false, false
+	}
 }
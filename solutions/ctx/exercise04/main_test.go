@@ -0,0 +1,250 @@
+package exercise04
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// buffered 返回一个已经装入 values 并关闭的 channel
+func buffered[T any](values ...T) <-chan T {
+	ch := make(chan T, len(values))
+	for _, v := range values {
+		ch <- v
+	}
+	close(ch)
+	return ch
+}
+
+// produce 返回一个无缓冲 channel，由新的 goroutine 依次发送 values 后关闭
+func produce[T any](values ...T) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		for _, v := range values {
+			ch <- v
+		}
+	}()
+	return ch
+}
+
+func rangeOf(from, n int) []int {
+	values := make([]int, n)
+	for i := range values {
+		values[i] = from + i
+	}
+	return values
+}
+
+// checkNoLeak 等待 goroutine 数量回落到 before，超时则失败
+func checkNoLeak(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("goroutines leaked: %d > %d\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestMerge_AllValues tests that every mode delivers every value from unbuffered sources and then closes.
+func TestMerge_AllValues(t *testing.T) {
+	for name, opts := range map[string]MergeOptions{
+		"unordered":   {},
+		"round-robin": {Fairness: RoundRobin, Buffer: 4},
+		"weighted":    {Fairness: Weighted, Weights: []int{3, 1}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			out := Merge(context.Background(), opts, produce(rangeOf(0, 100)...), produce(rangeOf(100, 50)...), produce[int]())
+			if cap(out) != opts.Buffer {
+				t.Errorf("output buffer = %d, want %d", cap(out), opts.Buffer)
+			}
+
+			var got []int
+			for v := range out {
+				got = append(got, v)
+			}
+			sort.Ints(got)
+			if len(got) != 150 {
+				t.Fatalf("got %d values, want 150", len(got))
+			}
+			for i, v := range got {
+				if v != i {
+					t.Fatalf("value %d missing or duplicated", i)
+				}
+			}
+		})
+	}
+}
+
+// TestMerge_Generic tests merging a non-int element type.
+func TestMerge_Generic(t *testing.T) {
+	out := Merge(context.Background(), MergeOptions{}, buffered("a", "b"), buffered("c"))
+	var got []string
+	for v := range out {
+		got = append(got, v)
+	}
+	sort.Strings(got)
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Fatalf("got %v, want [a b c]", got)
+	}
+}
+
+// TestMerge_Fairness tests that a busy source cannot starve the others and that weights are respected.
+func TestMerge_Fairness(t *testing.T) {
+	count := func(opts MergeOptions, take int) (a, b int) {
+		// 两个 source 的数据都已就绪，A 的数据远多于 B
+		out := Merge(context.Background(), opts, buffered(rangeOf(0, 1000)...), buffered(rangeOf(1000, 1000)...))
+		for i := 0; i < take; i++ {
+			if v := <-out; v < 1000 {
+				a++
+			} else {
+				b++
+			}
+		}
+		for range out {
+		}
+		return a, b
+	}
+
+	if a, b := count(MergeOptions{Fairness: RoundRobin}, 100); a < 45 || a > 55 {
+		t.Errorf("round-robin: got %d from A and %d from B, want about 50/50", a, b)
+	}
+	if a, b := count(MergeOptions{Fairness: Weighted, Weights: []int{3, 1}}, 100); a < 70 || a > 80 {
+		t.Errorf("weighted 3:1: got %d from A and %d from B, want about 75/25", a, b)
+	}
+}
+
+// TestMerge_Cancel tests that cancellation closes the output, stops every goroutine and
+// accounts for every value that was not delivered.
+func TestMerge_Cancel(t *testing.T) {
+	for name, fairness := range map[string]Fairness{"unordered": Unordered, "round-robin": RoundRobin, "weighted": Weighted} {
+		t.Run(name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			ctx, cancel := context.WithCancel(context.Background())
+
+			var (
+				mu      sync.Mutex
+				dropped []int
+				logged  int
+			)
+			opts := MergeOptions{
+				Fairness: fairness,
+				Weights:  []int{2, 2, 2},
+				Logf:     func(string, ...any) { mu.Lock(); logged++; mu.Unlock() },
+				OnDropped: func(d []int) {
+					mu.Lock()
+					dropped = append([]int(nil), d...)
+					mu.Unlock()
+				},
+			}
+			sources := []<-chan int{buffered(rangeOf(0, 50)...), buffered(rangeOf(50, 50)...), buffered(rangeOf(100, 50)...)}
+			out := Merge(ctx, opts, sources...)
+
+			received := 0
+			for range 10 {
+				<-out
+				received++
+			}
+			cancel()
+			for range out {
+				received++
+			}
+			checkNoLeak(t, before)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(dropped) != 3 {
+				t.Fatalf("OnDropped reported %v, want one count per source", dropped)
+			}
+			// 没有读出的值留在 source 里，不计入 dropped
+			total, left := received, 0
+			for i, d := range dropped {
+				total += d + len(sources[i])
+				left += len(sources[i])
+			}
+			if total != 150 {
+				t.Errorf("received %d + dropped %v + left %d = %d, want every one of the 150 values accounted for", received, dropped, left, total)
+			}
+			if logged != 1 {
+				t.Errorf("Logf called %d times, want 1", logged)
+			}
+		})
+	}
+}
+
+// TestMerge_CancelDoesNotDrainSources tests that values Merge never read stay in the caller's channels.
+func TestMerge_CancelDoesNotDrainSources(t *testing.T) {
+	for name, fairness := range map[string]Fairness{"unordered": Unordered, "round-robin": RoundRobin} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			var dropped []int
+			source := buffered(rangeOf(0, 50)...)
+			received := 0
+			for range Merge(ctx, MergeOptions{Fairness: fairness, OnDropped: func(d []int) { dropped = d }}, source) {
+				received++
+			}
+
+			// Merge 读出的值要么发送出去，要么计入 dropped；其余的值必须原样留在 source 里
+			if len(dropped) != 1 {
+				t.Fatalf("OnDropped reported %v, want one count per source", dropped)
+			}
+			left := len(source)
+			if received+dropped[0]+left != 50 {
+				t.Errorf("received %d + dropped %d + left %d, want all 50 values accounted for", received, dropped[0], left)
+			}
+			if left == 0 {
+				t.Error("expected values Merge never read to stay in the source")
+			}
+		})
+	}
+}
+
+// lateCancelCtx 模拟合并正常结束之后、finish 检查之前 ctx 才被取消：Err 已经返回错误，但 Done 从未关闭
+type lateCancelCtx struct{ context.Context }
+
+func (lateCancelCtx) Err() error { return context.Canceled }
+
+// TestMerge_NoDropAfterNormalEnd is a regression test: OnDropped used to fire with zero
+// counts whenever ctx was cancelled by the time every source had been read.
+func TestMerge_NoDropAfterNormalEnd(t *testing.T) {
+	called := false
+	opts := MergeOptions{OnDropped: func([]int) { called = true }, Logf: func(string, ...any) { called = true }}
+	received := 0
+	for range Merge(lateCancelCtx{context.Background()}, opts, buffered(1, 2), buffered(3)) {
+		received++
+	}
+	if received != 3 {
+		t.Fatalf("received %d values, want 3", received)
+	}
+	if called {
+		t.Error("OnDropped and Logf should not report a merge that ended normally")
+	}
+}
+
+// TestMerge_CancelBlockedSources tests that goroutines blocked on silent sources exit on cancellation.
+func TestMerge_CancelBlockedSources(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	silent := make(chan int)
+	out := Merge(ctx, MergeOptions{Fairness: RoundRobin}, silent, make(chan int))
+	cancel()
+	if _, ok := <-out; ok {
+		t.Fatal("expected the output to close without values")
+	}
+	checkNoLeak(t, before)
+
+	// 没有取消时不调用 OnDropped
+	called := false
+	for range Merge(context.Background(), MergeOptions{OnDropped: func([]int) { called = true }}, buffered(1)) {
+	}
+	if called {
+		t.Error("OnDropped should only be called on cancellation")
+	}
+}
@@ -0,0 +1,84 @@
+package exercise04
+
+import (
+	"container/heap"
+	"context"
+)
+
+// MergeSorted 把多个已按 less 排好序的 source 归并成一个全局有序的输出 channel，例如合并各个分片按时间排序的日志。
+// 只有每个没有关闭的 source 都给出了下一个值，才能确定最小值，因此会等待慢的 source，而不是先输出其他 source 的值。
+// 所有 source 关闭后关闭输出 channel；ctx 取消后内部 goroutine 退出并关闭输出 channel，未发送的值被丢弃。
+func MergeSorted[T any](ctx context.Context, less func(a, b T) bool, sources ...<-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		h := &sortedHeap[T]{less: less}
+
+		// next 阻塞读取第 i 个 source 的下一个值放入堆，source 关闭时不放入；ctx 取消时返回 false
+		next := func(i int) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case v, ok := <-sources[i]:
+				if ok {
+					heap.Push(h, sortedItem[T]{v: v, source: i})
+				}
+				return true
+			}
+		}
+
+		for i := range sources {
+			if !next(i) {
+				return
+			}
+		}
+		for h.Len() > 0 {
+			item := heap.Pop(h).(sortedItem[T])
+			select {
+			case <-ctx.Done():
+				return
+			case out <- item.v:
+			}
+			// 补上刚输出的 source 的下一个值，才能再次确定最小值
+			if !next(item.source) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// sortedItem 是堆中的一个值及其来源
+type sortedItem[T any] struct {
+	v      T
+	source int
+}
+
+// sortedHeap 是按 less 排序的最小堆，值相等时来源下标小的在前，使结果是确定的
+type sortedHeap[T any] struct {
+	items []sortedItem[T]
+	less  func(a, b T) bool
+}
+
+func (h *sortedHeap[T]) Len() int { return len(h.items) }
+
+func (h *sortedHeap[T]) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if h.less(a.v, b.v) {
+		return true
+	}
+	if h.less(b.v, a.v) {
+		return false
+	}
+	return a.source < b.source
+}
+
+func (h *sortedHeap[T]) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *sortedHeap[T]) Push(x any) { h.items = append(h.items, x.(sortedItem[T])) }
+
+func (h *sortedHeap[T]) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
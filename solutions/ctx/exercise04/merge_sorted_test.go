@@ -0,0 +1,102 @@
+package exercise04
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func intLess(a, b int) bool { return a < b }
+
+// TestMergeSorted tests the global order, including sources that close early and empty sources.
+func TestMergeSorted(t *testing.T) {
+	out := MergeSorted(context.Background(), intLess,
+		produce(1, 4, 7, 10, 13, 16),
+		produce(2, 5),
+		produce[int](),
+		buffered(0, 3, 3, 6, 20),
+	)
+	want := []int{0, 1, 2, 3, 3, 4, 5, 6, 7, 10, 13, 16, 20}
+	var got []int
+	for v := range out {
+		got = append(got, v)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+// TestMergeSorted_Stable tests that equal values keep the order of their sources.
+func TestMergeSorted_Stable(t *testing.T) {
+	type entry struct {
+		ts     int
+		source string
+	}
+	out := MergeSorted(context.Background(), func(a, b entry) bool { return a.ts < b.ts },
+		buffered(entry{1, "a"}, entry{2, "a"}),
+		buffered(entry{1, "b"}, entry{2, "b"}),
+	)
+	var got []string
+	for e := range out {
+		got = append(got, e.source)
+	}
+	if len(got) != 4 || got[0] != "a" || got[1] != "b" || got[2] != "a" || got[3] != "b" {
+		t.Fatalf("got sources %v, want [a b a b]", got)
+	}
+}
+
+// TestMergeSorted_WaitsForSlowSource tests that nothing is emitted until every open source has a head.
+func TestMergeSorted_WaitsForSlowSource(t *testing.T) {
+	slow := make(chan int)
+	out := MergeSorted(context.Background(), intLess, buffered(5, 6), slow)
+
+	select {
+	case v := <-out:
+		t.Fatalf("emitted %d before the slow source produced its head", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	slow <- 1
+	if v := <-out; v != 1 {
+		t.Fatalf("first value = %d, want 1 from the slow source", v)
+	}
+	// 慢 source 还没关闭，在它给出下一个值之前不能输出 5
+	select {
+	case v := <-out:
+		t.Fatalf("emitted %d while the slow source was still open without a head", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(slow)
+	for _, want := range []int{5, 6} {
+		if v := <-out; v != want {
+			t.Fatalf("got %d, want %d", v, want)
+		}
+	}
+	if _, ok := <-out; ok {
+		t.Fatal("expected the output to close")
+	}
+}
+
+// TestMergeSorted_Cancel tests that cancellation closes the output and leaks no goroutine,
+// whether the merge is blocked on a source or on the consumer.
+func TestMergeSorted_Cancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	blockedOnSource := MergeSorted(ctx, intLess, buffered(1), make(chan int))
+	blockedOnConsumer := MergeSorted(ctx, intLess, buffered(1, 2, 3))
+	<-blockedOnConsumer
+	cancel()
+
+	for range blockedOnSource {
+	}
+	for range blockedOnConsumer {
+	}
+	checkNoLeak(t, before)
+}
@@ -0,0 +1,138 @@
+package exercise04
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrMergerClosed 表示 Merger 已经关闭，不能再添加 source
+var ErrMergerClosed = errors.New("merger closed")
+
+// Merger 是可以在运行中添加和移除 source 的扇入，例如订阅服务随着客户端来去增删上游。
+// 输出 channel 在 Close 之后、所有已添加的 source 都关闭或被移除时关闭；ctx 取消相当于 Close 并立即停止所有 source。
+type Merger[T any] struct {
+	ctx  context.Context
+	out  chan T
+	stop func() bool // 取消 ctx 到 Close 的关联
+
+	mu        sync.Mutex
+	nextID    int
+	sources   map[int]*mergerSource
+	active    int // 还没退出的转发 goroutine，包括已经被 Remove 但还没退出的
+	closed    bool
+	outClosed bool
+}
+
+// mergerSource 是一个正在转发的 source
+type mergerSource struct {
+	remove chan struct{} // Remove 时关闭，让转发 goroutine 退出
+	done   chan struct{} // 转发 goroutine 退出后关闭
+}
+
+// NewMerger 创建输出缓冲为 buffer 的 Merger
+func NewMerger[T any](ctx context.Context, buffer int) *Merger[T] {
+	m := &Merger[T]{
+		ctx:     ctx,
+		out:     make(chan T, buffer),
+		sources: make(map[int]*mergerSource),
+	}
+	m.stop = context.AfterFunc(ctx, m.Close)
+	return m
+}
+
+// Out 返回输出 channel
+func (m *Merger[T]) Out() <-chan T {
+	return m.out
+}
+
+// Add 开始转发 ch 中的值，返回用于 Remove 的 id。Merger 已经关闭时返回 ErrMergerClosed。
+func (m *Merger[T]) Add(ch <-chan T) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return 0, ErrMergerClosed
+	}
+	id := m.nextID
+	m.nextID++
+	s := &mergerSource{remove: make(chan struct{}), done: make(chan struct{})}
+	m.sources[id] = s
+	m.active++
+	go m.forward(id, s, ch)
+	return id, nil
+}
+
+// Remove 停止转发 id 对应的 source，返回后不会再有该 source 的值被发送到输出 channel。
+// 已经读出但还没发送的值会被丢弃，source 本身不会被关闭。id 不存在或 source 已经结束时返回 false。
+func (m *Merger[T]) Remove(id int) bool {
+	m.mu.Lock()
+	s, ok := m.sources[id]
+	if ok {
+		delete(m.sources, id)
+		close(s.remove)
+	}
+	m.mu.Unlock()
+	if !ok {
+		return false
+	}
+	<-s.done
+	return true
+}
+
+// Len 返回正在转发的 source 数量
+func (m *Merger[T]) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sources)
+}
+
+// Close 不再接受新的 source。已添加的 source 继续转发，全部结束后关闭输出 channel。可以多次调用。
+func (m *Merger[T]) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.maybeCloseOut()
+}
+
+// forward 把 ch 中的值转发到输出 channel，直到 ch 关闭、被 Remove 或 ctx 取消
+func (m *Merger[T]) forward(id int, s *mergerSource, ch <-chan T) {
+	defer func() {
+		m.mu.Lock()
+		if m.sources[id] == s {
+			delete(m.sources, id)
+		}
+		m.active--
+		m.maybeCloseOut()
+		m.mu.Unlock()
+		close(s.done)
+	}()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-s.remove:
+			return
+		case v, ok := <-ch:
+			if !ok {
+				return
+			}
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-s.remove:
+				return
+			case m.out <- v:
+			}
+		}
+	}
+}
+
+// maybeCloseOut 在关闭后且所有转发 goroutine 都退出时关闭输出 channel，调用方需持有锁
+func (m *Merger[T]) maybeCloseOut() {
+	if !m.closed || m.outClosed || m.active > 0 {
+		return
+	}
+	m.outClosed = true
+	m.stop()
+	close(m.out)
+}
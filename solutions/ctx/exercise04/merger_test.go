@@ -0,0 +1,170 @@
+package exercise04
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// endless 返回一个不断发送 v 的 channel，直到 stop 关闭
+func endless[T any](v T, stop <-chan struct{}) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case <-stop:
+				return
+			case ch <- v:
+			}
+		}
+	}()
+	return ch
+}
+
+// TestMerger_AddRemove tests adding and removing sources while values are flowing.
+func TestMerger_AddRemove(t *testing.T) {
+	before := runtime.NumGoroutine()
+	stop := make(chan struct{})
+
+	m := NewMerger[string](context.Background(), 0)
+	a, err := m.Add(endless("a", stop))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for !seen["a"] {
+		seen[<-m.Out()] = true
+	}
+
+	if _, err := m.Add(endless("b", stop)); err != nil {
+		t.Fatal(err)
+	}
+	for !seen["b"] {
+		seen[<-m.Out()] = true
+	}
+
+	// Remove 返回后不会再收到 a 的值
+	if !m.Remove(a) {
+		t.Fatal("Remove should report an attached source")
+	}
+	if m.Remove(a) {
+		t.Fatal("removing the same source twice should return false")
+	}
+	for i := 0; i < 100; i++ {
+		if v := <-m.Out(); v != "b" {
+			t.Fatalf("received %q after its source was removed", v)
+		}
+	}
+	if n := m.Len(); n != 1 {
+		t.Fatalf("Len = %d, want 1", n)
+	}
+
+	m.Close()
+	if _, err := m.Add(endless("c", stop)); !errors.Is(err, ErrMergerClosed) {
+		t.Fatalf("Add after Close: got %v, want ErrMergerClosed", err)
+	}
+	// 剩下的 source 还在转发，移除之后输出 channel 才关闭
+	for id := 0; id < 2; id++ {
+		m.Remove(id)
+	}
+	for range m.Out() {
+	}
+	close(stop)
+	checkNoLeak(t, before)
+}
+
+// TestMerger_CloseWaitsForSources tests that the output closes only after every attached source is done.
+func TestMerger_CloseWaitsForSources(t *testing.T) {
+	m := NewMerger[int](context.Background(), 10)
+	src := make(chan int)
+	if _, err := m.Add(src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Add(buffered(1, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	m.Close()
+
+	src <- 4
+	got := 0
+	timeout := time.After(20 * time.Millisecond)
+loop:
+	for {
+		select {
+		case _, ok := <-m.Out():
+			if !ok {
+				t.Fatal("output closed while a source was still attached")
+			}
+			got++
+		case <-timeout:
+			break loop
+		}
+	}
+	if got != 4 {
+		t.Fatalf("got %d values, want 4", got)
+	}
+
+	close(src)
+	if _, ok := <-m.Out(); ok {
+		t.Fatal("expected the output to close once the last source closed")
+	}
+}
+
+// TestMerger_Cancel tests that cancelling ctx stops every source and closes the output without leaks.
+func TestMerger_Cancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewMerger[int](ctx, 0)
+	silent := make(chan int)
+	m.Add(silent)
+	m.Add(make(chan int))
+
+	cancel()
+	for range m.Out() {
+	}
+	if _, err := m.Add(silent); !errors.Is(err, ErrMergerClosed) {
+		t.Fatalf("Add after cancel: got %v, want ErrMergerClosed", err)
+	}
+	checkNoLeak(t, before)
+}
+
+// TestMerger_Concurrent tests concurrent Add and Remove while a consumer drains the output.
+func TestMerger_Concurrent(t *testing.T) {
+	m := NewMerger[int](context.Background(), 0)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for range m.Out() {
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				id, err := m.Add(endless(i, stop))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				m.Remove(id)
+			}
+		}()
+	}
+	wg.Wait()
+	m.Close()
+	<-drained
+	if n := m.Len(); n != 0 {
+		t.Fatalf("Len = %d after all removals, want 0", n)
+	}
+}
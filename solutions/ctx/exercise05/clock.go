@@ -0,0 +1,229 @@
+package exercise05
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock 抽象了限流器和工作池用到的时间操作，测试中可以替换为手动推进的 ManualClock，
+// 让依赖速率的测试不必真实等待。
+type Clock interface {
+	Now() time.Time
+	// NewTimer 创建一个在 d 之后向 C() 发送当前时间的定时器
+	NewTimer(d time.Duration) Timer
+	// AfterFunc 创建一个在 d 之后在独立的 goroutine 中调用 f 的定时器
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer 是 *time.Timer 的抽象。通过 AfterFunc 创建的定时器 C() 返回 nil。
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Option 用于配置限流器和工作池的可选参数
+type Option func(*options)
+
+type options struct {
+	clock Clock
+}
+
+// WithClock 指定使用的时钟，默认使用系统时间
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// applyOptions 合并可选参数并补全默认值
+func applyOptions(opts []Option) options {
+	o := options{clock: realClock{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// realClock 使用系统时间
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+// realTimer 包装 *time.Timer 以实现 Timer 接口
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+// ManualClock 是一个只在调用 Advance/Set 时才前进的时钟，到期的定时器在推进时同步触发。
+// 配合 BlockUntil 可以精确地控制“先有人在等，再让时间流逝”的顺序，使速率相关的测试确定且快速。
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*manualTimer
+	changed chan struct{} // 定时器数量变化时关闭，用于唤醒 BlockUntil
+}
+
+// NewManualClock 创建一个从 start 时刻开始的手动时钟
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start, changed: make(chan struct{})}
+}
+
+// Now 返回手动时钟的当前时间
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer 创建一个在时钟推进 d 之后触发的定时器
+func (c *ManualClock) NewTimer(d time.Duration) Timer {
+	t := &manualTimer{clock: c, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// AfterFunc 创建一个在时钟推进 d 之后调用 f 的定时器。f 在 Advance 的调用方 goroutine 中同步执行，
+// 因此 Advance 返回时所有到期的回调都已经执行完毕。
+// 例外是 d <= 0（创建时或 Reset 时）：此时不需要推进时钟，f 立即在新的 goroutine 中异步执行，
+// 因为创建或重置定时器的调用方可能正持有 f 需要的锁。
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &manualTimer{clock: c, fn: f}
+	t.Reset(d)
+	return t
+}
+
+// Advance 把时钟推进 d，并按到期时间顺序触发途经的所有定时器
+func (c *ManualClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set 把时钟推进到 t，并按到期时间顺序触发途经的所有定时器。t 早于当前时间时不做任何事。
+func (c *ManualClock) Set(t time.Time) {
+	for {
+		c.mu.Lock()
+		if t.Before(c.now) {
+			c.mu.Unlock()
+			return
+		}
+		// 找到最早到期且不晚于 t 的定时器，把时间拨到它的到期时刻再触发，
+		// 这样回调中读取到的 Now() 与真实时钟一致
+		sort.Slice(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+		if len(c.timers) == 0 || c.timers[0].when.After(t) {
+			c.now = t
+			c.mu.Unlock()
+			return
+		}
+		timer := c.timers[0]
+		c.timers = c.timers[1:]
+		c.notifyLocked()
+		if timer.when.After(c.now) {
+			c.now = timer.when
+		}
+		now := c.now
+		c.mu.Unlock()
+
+		if timer.fn != nil {
+			timer.fn()
+		} else {
+			select {
+			case timer.ch <- now:
+			default:
+			}
+		}
+	}
+}
+
+// Timers 返回尚未触发的定时器数量
+func (c *ManualClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil 阻塞直到恰好有 n 个尚未触发的定时器，用来等待被测代码进入等待状态
+func (c *ManualClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		if len(c.timers) == n {
+			c.mu.Unlock()
+			return
+		}
+		changed := c.changed
+		c.mu.Unlock()
+		<-changed
+	}
+}
+
+// notifyLocked 唤醒 BlockUntil，调用方需持有锁
+func (c *ManualClock) notifyLocked() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// removeLocked 把定时器从待触发列表中移除，返回它之前是否在列表中，调用方需持有锁
+func (c *ManualClock) removeLocked(t *manualTimer) bool {
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			c.notifyLocked()
+			return true
+		}
+	}
+	return false
+}
+
+// manualTimer 是 ManualClock 创建的定时器
+type manualTimer struct {
+	clock *ManualClock
+	when  time.Time
+	ch    chan time.Time
+	fn    func()
+}
+
+func (t *manualTimer) C() <-chan time.Time { return t.ch }
+
+func (t *manualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.removeLocked(t)
+}
+
+func (t *manualTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := c.removeLocked(t)
+
+	// 不需要等待的定时器立即触发，与 time.NewTimer(0) 的行为一致。
+	// 回调放到新的 goroutine 中执行，调用方可能正持有回调需要的锁。
+	if d <= 0 {
+		if t.fn != nil {
+			go t.fn()
+		} else {
+			select {
+			case t.ch <- c.now:
+			default:
+			}
+		}
+		return active
+	}
+
+	t.when = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	c.notifyLocked()
+	return active
+}
@@ -0,0 +1,62 @@
+package exercise05
+
+import (
+	"testing"
+	"time"
+)
+
+// TestManualClock tests that timers fire only when the clock is advanced past them, in order.
+func TestManualClock(t *testing.T) {
+	t.Run("should fire due timers in order with the clock set to their deadline", func(t *testing.T) {
+		start := time.Unix(0, 0)
+		clock := NewManualClock(start)
+
+		var fired []time.Duration
+		clock.AfterFunc(30*time.Millisecond, func() { fired = append(fired, clock.Now().Sub(start)) })
+		clock.AfterFunc(10*time.Millisecond, func() { fired = append(fired, clock.Now().Sub(start)) })
+		timer := clock.NewTimer(20 * time.Millisecond)
+
+		clock.Advance(25 * time.Millisecond)
+		if len(fired) != 1 || fired[0] != 10*time.Millisecond {
+			t.Fatalf("expected only the 10ms callback to fire, got %v", fired)
+		}
+		select {
+		case now := <-timer.C():
+			if got := now.Sub(start); got != 20*time.Millisecond {
+				t.Errorf("expected the timer to fire at 20ms, got %v", got)
+			}
+		default:
+			t.Fatal("expected the 20ms timer to have fired")
+		}
+
+		clock.Advance(5 * time.Millisecond)
+		if len(fired) != 2 || fired[1] != 30*time.Millisecond {
+			t.Errorf("expected the 30ms callback to fire, got %v", fired)
+		}
+		if got := clock.Timers(); got != 0 {
+			t.Errorf("expected no pending timers, got %d", got)
+		}
+	})
+
+	t.Run("stopped and reset timers", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		timer := clock.NewTimer(time.Second)
+		if !timer.Stop() {
+			t.Error("expected Stop to report an active timer")
+		}
+		clock.Advance(2 * time.Second)
+		select {
+		case <-timer.C():
+			t.Fatal("a stopped timer should not fire")
+		default:
+		}
+
+		timer.Reset(time.Second)
+		clock.Advance(time.Second)
+		select {
+		case <-timer.C():
+		default:
+			t.Fatal("expected the reset timer to fire")
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"exercise05"
+)
+
+// ratesim 在虚拟时钟上回放一份请求轨迹，对比不同限流算法和参数下的放行、拒绝、等待时间与各 key 的公平性。
+//
+//	ratesim -rate 100 -burst 20 -max-wait 200ms access.csv
+func main() {
+	var (
+		format     = flag.String("format", "", "trace format: csv or jsonl (default: by file extension)")
+		algorithms = flag.String("algo", "all", "comma separated algorithms, or all: "+strings.Join(exercise05.SimAlgorithms, ","))
+		rate       = flag.Float64("rate", 10, "allowed requests per second")
+		burst      = flag.Int("burst", 10, "burst for tokenbucket/gcra, queue capacity for leaky")
+		window     = flag.Duration("window", time.Second, "window length for the window algorithms")
+		perKey     = flag.Bool("per-key", false, "give every key its own limiter instead of sharing one")
+		maxWait    = flag.Duration("max-wait", 0, "how long a rejected request may queue before giving up")
+		resolution = flag.Duration("resolution", time.Millisecond, "virtual time step for retrying queued requests")
+		topKeys    = flag.Int("top", 10, "number of busiest keys to list")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: ratesim [flags] [trace file, default stdin]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	if path := flag.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+		if *format == "" && filepath.Ext(path) != ".csv" {
+			*format = "jsonl"
+		}
+	}
+	if *format == "" {
+		*format = "csv"
+	}
+	events, err := exercise05.ReadTrace(in, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	names := exercise05.SimAlgorithms
+	if *algorithms != "all" {
+		names = strings.Split(*algorithms, ",")
+	}
+	for i, name := range names {
+		res, err := exercise05.Simulate(events, exercise05.SimConfig{
+			Algorithm:  strings.TrimSpace(name),
+			Rate:       exercise05.Limit(*rate),
+			Burst:      *burst,
+			Window:     *window,
+			PerKey:     *perKey,
+			MaxWait:    *maxWait,
+			Resolution: *resolution,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := res.WriteReport(os.Stdout, *topKeys); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os/signal"
+	"syscall"
+
+	"exercise05"
+)
+
+// ratestore 启动一个共享限流状态的 TCP 服务，多个进程通过 exercise05.NewRemoteStore 连接它，
+// 再用 exercise05.NewStoreLimiter 共同遵守同一个限额。
+func main() {
+	addr := flag.String("listen", "127.0.0.1:7070", "address to listen on")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := exercise05.NewStoreServer(exercise05.NewMemoryStore())
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("rate limit store listening on %s", ln.Addr())
+	if err := server.Serve(ln); err != nil {
+		log.Fatal(err)
+	}
+}
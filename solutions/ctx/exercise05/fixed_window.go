@@ -0,0 +1,60 @@
+package exercise05
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FixedWindow 实现了固定窗口计数器：把时间切成长度为 window 的窗口，每个窗口内最多放行 limit 个请求。
+// 实现最简单，但在窗口边界两侧可能出现两倍于 limit 的突发。
+type FixedWindow struct {
+	limit  int
+	window time.Duration
+	start  time.Time // 当前窗口的起始时间
+	count  int       // 当前窗口内已放行的请求数
+	clock  Clock
+	mu     sync.Mutex
+}
+
+// NewFixedWindow 创建一个每 window 时间最多放行 limit 个请求的固定窗口限流器
+func NewFixedWindow(limit int, window time.Duration, opts ...Option) *FixedWindow {
+	return &FixedWindow{
+		limit:  limit,
+		window: window,
+		clock:  applyOptions(opts).clock,
+	}
+}
+
+// try 尝试在 now 时刻放行一个请求，失败时返回距离下一个窗口开始的时间，调用方需持有锁
+func (fw *FixedWindow) try(now time.Time) (bool, time.Duration) {
+	if fw.limit <= 0 {
+		return false, never
+	}
+	if start := now.Truncate(fw.window); !start.Equal(fw.start) {
+		fw.start = start
+		fw.count = 0
+	}
+	if fw.count < fw.limit {
+		fw.count++
+		return true, 0
+	}
+	return false, fw.start.Add(fw.window).Sub(now)
+}
+
+// Allow 在当前窗口还有余量时放行
+func (fw *FixedWindow) Allow() bool {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	ok, _ := fw.try(fw.clock.Now())
+	return ok
+}
+
+// Wait 阻塞直到当前窗口或之后的某个窗口有余量
+func (fw *FixedWindow) Wait(ctx context.Context) error {
+	return waitUntilAllowed(ctx, fw.clock, func(now time.Time) (bool, time.Duration) {
+		fw.mu.Lock()
+		defer fw.mu.Unlock()
+		return fw.try(now)
+	})
+}
@@ -0,0 +1,102 @@
+package exercise05
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// GCRA 实现了通用信元速率算法（Generic Cell Rate Algorithm）。
+// 它只记录一个“理论到达时间”（TAT），效果与同样速率和突发量的令牌桶等价，但状态只有一个时间戳，
+// 适合存放在共享存储中。
+type GCRA struct {
+	limit    Limit
+	interval time.Duration // 每个请求占用的时间，即 1/limit
+	burst    int
+	tat      time.Time // 理论到达时间：按速率排下去，下一个请求“应该”到达的时刻
+	clock    Clock
+	mu       sync.Mutex
+}
+
+// NewGCRA 创建一个速率为 limit、最多允许 burst 个请求突发的 GCRA 限流器。
+// limit <= 0 或 burst <= 0 时所有请求都会被拒绝。
+func NewGCRA(limit Limit, burst int, opts ...Option) *GCRA {
+	return &GCRA{
+		limit:    limit,
+		interval: limit.durationFromTokens(1),
+		burst:    burst,
+		clock:    applyOptions(opts).clock,
+	}
+}
+
+// reserve 计算在 now 时刻放行一个请求后的新 TAT 以及需要等待的时间，调用方需持有锁
+func (g *GCRA) reserve(now time.Time) (newTAT time.Time, delay time.Duration) {
+	tat := g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT = tat.Add(g.interval)
+	// 允许新 TAT 超前当前时间最多 burst 个间隔
+	allowAt := newTAT.Add(-time.Duration(g.burst) * g.interval)
+	return newTAT, allowAt.Sub(now)
+}
+
+// Allow 在新 TAT 没有超出突发容忍度时放行
+func (g *GCRA) Allow() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.limit == Inf {
+		return true
+	}
+	if g.limit <= 0 || g.burst <= 0 {
+		return false
+	}
+	newTAT, delay := g.reserve(g.clock.Now())
+	if delay > 0 {
+		return false
+	}
+	g.tat = newTAT
+	return true
+}
+
+// Wait 立即推进 TAT 为请求预订位置，再等待到可以放行的时刻，因此并发的等待者按预订顺序放行。
+// 等待期间被取消时，如果后面没有人预订，会把 TAT 退回去。
+func (g *GCRA) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	g.mu.Lock()
+	if g.limit == Inf {
+		g.mu.Unlock()
+		return nil
+	}
+	if g.limit <= 0 || g.burst <= 0 {
+		g.mu.Unlock()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	newTAT, delay := g.reserve(g.clock.Now())
+	g.tat = newTAT
+	g.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := g.clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		if g.tat.Equal(newTAT) {
+			g.tat = newTAT.Add(-g.interval)
+		}
+		g.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,137 @@
+package exercise05
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrLimitExceeded 表示某一层当前没有足够的令牌
+	ErrLimitExceeded = errors.New("rate limit exceeded")
+	// ErrExceedsBurst 表示请求的令牌数超过了某一层的容量，或该层速率为 0 且令牌不足，永远无法满足
+	ErrExceedsBurst = errors.New("request exceeds burst")
+	// ErrExceedsDeadline 表示需要等待的时间超过了 ctx 的截止时间
+	ErrExceedsDeadline = errors.New("wait would exceed context deadline")
+)
+
+// Level 是分层限流中的一层，例如全局、租户或用户
+type Level struct {
+	Name   string
+	Bucket *TokenBucket
+}
+
+// LimitError 说明分层限流中是哪一层拒绝了请求
+type LimitError struct {
+	Level string        // 拒绝请求的层
+	Delay time.Duration // 该层需要等待的时间，永远无法满足时为 never
+	Err   error         // ErrLimitExceeded、ErrExceedsBurst 或 ErrExceedsDeadline
+}
+
+func (e *LimitError) Error() string {
+	if e.Delay > 0 && e.Delay != never {
+		return fmt.Sprintf("%s: %v (retry after %v)", e.Level, e.Err, e.Delay)
+	}
+	return fmt.Sprintf("%s: %v", e.Level, e.Err)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// reserveAll 在每一层预订 n 个令牌。任意一层无法预订时取消已经做出的预订，并返回该层的错误；
+// 否则返回所有预订、需要等待的最长时间以及决定这个时间的层。
+func reserveAll(n int, levels []Level) (reservations []*Reservation, delay time.Duration, slowest string, err error) {
+	reservations = make([]*Reservation, 0, len(levels))
+	for _, level := range levels {
+		r := level.Bucket.Reserve(n)
+		if !r.OK() {
+			cancelAll(reservations)
+			return nil, 0, "", &LimitError{Level: level.Name, Delay: never, Err: ErrExceedsBurst}
+		}
+		reservations = append(reservations, r)
+		if d := r.Delay(); d > delay {
+			delay, slowest = d, level.Name
+		}
+	}
+	return reservations, delay, slowest, nil
+}
+
+// cancelAll 按预订的相反顺序归还令牌
+func cancelAll(reservations []*Reservation) {
+	for i := len(reservations) - 1; i >= 0; i-- {
+		reservations[i].Cancel()
+	}
+}
+
+// TryAll 非阻塞地从每一层取走 n 个令牌：要么所有层都有足够的令牌并全部取走，
+// 要么一个都不取，并返回 *LimitError 说明是哪一层不够。
+func TryAll(n int, levels ...Level) error {
+	reservations, delay, slowest, err := reserveAll(n, levels)
+	if err != nil {
+		return err
+	}
+	if delay > 0 {
+		cancelAll(reservations)
+		return &LimitError{Level: slowest, Delay: delay, Err: ErrLimitExceeded}
+	}
+	return nil
+}
+
+// WaitAll 阻塞直到可以从每一层取走 n 个令牌。各层的令牌在调用时一次性预订，
+// 等待时间取各层中最长的一个；如果这个时间超过了 ctx 的截止时间，或者等待期间 ctx 结束，
+// 所有预订都会被取消，不会有任何一层的令牌被泄漏。
+func WaitAll(ctx context.Context, n int, levels ...Level) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(levels) == 0 {
+		return nil
+	}
+
+	reservations, delay, slowest, err := reserveAll(n, levels)
+	if err != nil {
+		return err
+	}
+	if delay <= 0 {
+		return nil
+	}
+	// 截止时间与令牌桶使用同一个时钟比较，注入 ManualClock 时判断结果与定时器一致
+	clock := levels[0].Bucket.clock
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(clock.Now()) < delay {
+		cancelAll(reservations)
+		return &LimitError{Level: slowest, Delay: delay, Err: ErrExceedsDeadline}
+	}
+
+	timer := clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		cancelAll(reservations)
+		return ctx.Err()
+	}
+}
+
+// HierarchicalLimiter 把固定的几层限额组合成一个 Limiter，例如全局和租户两层，
+// 每次放行都同时消耗每一层的令牌。
+type HierarchicalLimiter struct {
+	levels []Level
+}
+
+// NewHierarchicalLimiter 创建一个依次检查 levels 的分层限流器
+func NewHierarchicalLimiter(levels ...Level) *HierarchicalLimiter {
+	return &HierarchicalLimiter{levels: levels}
+}
+
+// Allow 在每一层都有令牌时放行，并从每一层各取走一个令牌
+func (h *HierarchicalLimiter) Allow() bool {
+	return TryAll(1, h.levels...) == nil
+}
+
+// Wait 阻塞直到可以从每一层各取走一个令牌，拒绝时返回 *LimitError 说明是哪一层
+func (h *HierarchicalLimiter) Wait(ctx context.Context) error {
+	return WaitAll(ctx, 1, h.levels...)
+}
@@ -0,0 +1,164 @@
+package exercise05
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newLevels 创建全局 5/s、租户 3/s、用户 2/s 三层限额，突发量与速率相同
+func newLevels(clock Clock) (global, tenant, user Level) {
+	global = Level{"global", NewTokenBucketWithBurst(5, 5, WithClock(clock))}
+	tenant = Level{"tenant", NewTokenBucketWithBurst(3, 3, WithClock(clock))}
+	user = Level{"user", NewTokenBucketWithBurst(2, 2, WithClock(clock))}
+	return global, tenant, user
+}
+
+// TestTryAll tests the all-or-nothing non-blocking check.
+func TestTryAll(t *testing.T) {
+	t.Run("should not leak outer tokens when an inner level rejects", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		global, tenant, user := newLevels(clock)
+
+		for i := 0; i < 2; i++ {
+			if err := TryAll(1, global, tenant, user); err != nil {
+				t.Fatalf("request %d: unexpected error: %v", i, err)
+			}
+		}
+
+		err := TryAll(1, global, tenant, user)
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Level != "user" || !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("expected the user level to reject, got %v", err)
+		}
+		if got := global.Bucket.Tokens(); got != 3 {
+			t.Errorf("expected global to keep 3 tokens, got %v", got)
+		}
+		if got := tenant.Bucket.Tokens(); got != 1 {
+			t.Errorf("expected tenant to keep 1 token, got %v", got)
+		}
+	})
+
+	t.Run("should report a request larger than a burst", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		global, tenant, user := newLevels(clock)
+
+		err := TryAll(3, global, tenant, user)
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Level != "user" || !errors.Is(err, ErrExceedsBurst) {
+			t.Fatalf("expected the user level to reject 3 tokens as above its burst, got %v", err)
+		}
+		if got := global.Bucket.Tokens(); got != 5 {
+			t.Errorf("expected global to be untouched, got %v", got)
+		}
+	})
+}
+
+// TestWaitAll tests waiting on several levels at once.
+func TestWaitAll(t *testing.T) {
+	t.Run("should wait for the slowest level and take from all", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		global, tenant, user := newLevels(clock)
+		for i := 0; i < 2; i++ {
+			_ = TryAll(1, global, tenant, user)
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- WaitAll(context.Background(), 1, global, tenant, user) }()
+		clock.BlockUntil(1)
+
+		// 用户层 2/s，需要等待 500ms；其他层此时还有令牌
+		clock.Advance(499 * time.Millisecond)
+		select {
+		case err := <-done:
+			t.Fatalf("expected to wait for the user level, returned %v", err)
+		default:
+		}
+		clock.Advance(time.Millisecond)
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// 租户层 3 - 2 - 1 = 0 个令牌，500ms 内按 3/s 补充 1.5 个
+		if got := tenant.Bucket.Tokens(); got < 1.49 || got > 1.51 {
+			t.Errorf("expected tenant to have 1.5 tokens after the wait, got %v", got)
+		}
+	})
+
+	t.Run("should give tokens back when the deadline is too close", func(t *testing.T) {
+		// 时钟比真实时间快一小时：按系统时间截止时间还有一个多小时，按注入的时钟只剩 1s，
+		// 判断必须以注入的时钟为准
+		clock := NewManualClock(time.Now().Add(time.Hour))
+		global := Level{"global", NewTokenBucketWithBurst(10, 10, WithClock(clock))}
+		tenant := Level{"tenant", NewTokenBucketWithBurst(Every(time.Hour), 1, WithClock(clock))}
+		tenant.Bucket.Allow()
+
+		ctx, cancel := context.WithDeadline(context.Background(), clock.Now().Add(time.Second))
+		defer cancel()
+		err := WaitAll(ctx, 1, global, tenant)
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Level != "tenant" || !errors.Is(err, ErrExceedsDeadline) {
+			t.Fatalf("expected the tenant level to exceed the deadline, got %v", err)
+		}
+		if limitErr.Delay != time.Hour {
+			t.Errorf("expected the reported delay to be 1h, got %v", limitErr.Delay)
+		}
+		if got := global.Bucket.Tokens(); got != 10 {
+			t.Errorf("expected global to keep all 10 tokens, got %v", got)
+		}
+	})
+
+	t.Run("should give tokens back when cancelled while waiting", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		global := Level{"global", NewTokenBucketWithBurst(10, 10, WithClock(clock))}
+		user := Level{"user", NewTokenBucketWithBurst(1, 1, WithClock(clock))}
+		user.Bucket.Allow()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- WaitAll(ctx, 1, global, user) }()
+		clock.BlockUntil(1)
+		cancel()
+
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		if got := global.Bucket.Tokens(); got != 10 {
+			t.Errorf("expected global to get its token back, got %v", got)
+		}
+		if got := user.Bucket.Tokens(); got != 0 {
+			t.Errorf("expected user to be back at 0 tokens, got %v", got)
+		}
+	})
+}
+
+// TestHierarchicalLimiter_WithKeyedLimiter tests combining a global bucket with per-user keyed buckets.
+func TestHierarchicalLimiter_WithKeyedLimiter(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	global := Level{"global", NewTokenBucketWithBurst(3, 3, WithClock(clock))}
+	users := NewKeyedLimiter(2, 2, 0, WithClock(clock))
+
+	allow := func(user string) error {
+		bucket, release := users.Bucket(user)
+		defer release()
+		return TryAll(1, global, Level{"user " + user, bucket})
+	}
+
+	if allow("alice") != nil || allow("alice") != nil {
+		t.Fatal("expected alice's first 2 requests to be allowed")
+	}
+	if err := allow("alice"); err == nil || err.(*LimitError).Level != "user alice" {
+		t.Fatalf("expected alice to be limited at the user level, got %v", err)
+	}
+	if err := allow("bob"); err != nil {
+		t.Fatalf("expected bob to use the last global token, got %v", err)
+	}
+	if err := allow("carol"); err == nil || err.(*LimitError).Level != "global" {
+		t.Errorf("expected carol to be limited at the global level, got %v", err)
+	}
+
+	var l Limiter = NewHierarchicalLimiter(global)
+	if l.Allow() {
+		t.Error("expected the exhausted global level to reject")
+	}
+}
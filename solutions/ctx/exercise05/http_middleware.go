@@ -0,0 +1,226 @@
+package exercise05
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyFunc 从请求中提取限流使用的 key，同一个 key 的请求共享一个令牌桶
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP 按客户端 IP 限流。只使用 RemoteAddr，部署在反向代理之后时应改用自定义的 KeyFunc
+// 从可信的转发头中取真实 IP。
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// KeyByHeader 按请求头（例如 X-API-Key）限流，请求没有携带该头时退回到按客户端 IP 限流。
+// 头的值完全由客户端决定，每次换一个值就能得到一个新的令牌桶，因此只适用于上游已经校验过该头的场景；
+// 否则应使用 KeyByAPIKey。
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			return name + ":" + v
+		}
+		return KeyByIP(r)
+	}
+}
+
+// KeyByAPIKey 按请求头中经过 valid 校验的 API key 限流。没有携带该头或 key 无效时按客户端 IP 限流，
+// 伪造的 key 不会得到单独的令牌桶。
+func KeyByAPIKey(header string, valid func(key string) bool) KeyFunc {
+	return func(r *http.Request) string {
+		if v := r.Header.Get(header); v != "" && valid(v) {
+			return header + ":" + v
+		}
+		return KeyByIP(r)
+	}
+}
+
+// httpMaxKeys 是每个路由最多同时跟踪的客户端数量，超过后按 LRU 淘汰
+const httpMaxKeys = 1 << 20
+
+// maxRetryAfter 是 Retry-After 和 RateLimit-Reset 的上限。速率极低时算出的等待时间可能长达数百年，
+// 告诉客户端一个小时后再试更有用
+const maxRetryAfter = time.Hour
+
+// routeLimit 是某个路由前缀上的限额，每个 key 在每个路由上有独立的令牌桶
+type routeLimit struct {
+	method string // 为空表示匹配所有方法
+	prefix string
+	keys   *KeyedLimiter
+}
+
+// HTTPRateLimiter 是基于 TokenBucket 的 HTTP 限流中间件，每个路由用一个 KeyedLimiter 管理各个客户端的令牌桶。
+// 被拒绝的请求返回 429 和 Retry-After；所有响应都带有 RateLimit-Limit、RateLimit-Remaining
+// 和 RateLimit-Reset 头，分别表示突发额度、剩余额度和额度完全恢复还需要的秒数。
+type HTTPRateLimiter struct {
+	keyFunc KeyFunc
+	opts    []Option
+	def     *routeLimit
+	mu      sync.RWMutex
+	routes  []*routeLimit // 按前缀长度降序排列，优先匹配最具体的路由
+}
+
+// NewHTTPRateLimiter 创建一个默认限额为 limit/burst、按 keyFunc 区分客户端的中间件，keyFunc 为 nil 时按 IP 限流
+func NewHTTPRateLimiter(limit Limit, burst int, keyFunc KeyFunc, opts ...Option) *HTTPRateLimiter {
+	if keyFunc == nil {
+		keyFunc = KeyByIP
+	}
+	return &HTTPRateLimiter{
+		keyFunc: keyFunc,
+		opts:    opts,
+		def:     &routeLimit{keys: NewKeyedLimiter(limit, burst, httpMaxKeys, opts...)},
+	}
+}
+
+// Route 为匹配 pattern 的请求设置单独的限额。pattern 的格式与 http.ServeMux 类似：
+// "/upload/" 按路径前缀匹配所有方法，"POST /upload/" 只匹配 POST 请求。前缀按完整的路径段匹配，
+// "/api" 匹配 "/api" 和 "/api/..."，但不匹配 "/apiary"。多个路由都匹配时取前缀最长的一个，
+// 前缀相同时指定了方法的路由优先。
+func (h *HTTPRateLimiter) Route(pattern string, limit Limit, burst int) *HTTPRateLimiter {
+	rl := &routeLimit{prefix: pattern, keys: NewKeyedLimiter(limit, burst, httpMaxKeys, h.opts...)}
+	if method, prefix, ok := strings.Cut(pattern, " "); ok {
+		rl.method, rl.prefix = method, strings.TrimSpace(prefix)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.routes = append(h.routes, rl)
+	sort.SliceStable(h.routes, func(i, j int) bool {
+		a, b := h.routes[i], h.routes[j]
+		if len(a.prefix) != len(b.prefix) {
+			return len(a.prefix) > len(b.prefix)
+		}
+		return a.method != "" && b.method == ""
+	})
+	return h
+}
+
+// match 返回请求对应的限额
+func (h *HTTPRateLimiter) match(r *http.Request) *routeLimit {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, rl := range h.routes {
+		if (rl.method == "" || rl.method == r.Method) && matchPrefix(r.URL.Path, rl.prefix) {
+			return rl
+		}
+	}
+	return h.def
+}
+
+// matchPrefix 判断 path 是否位于 prefix 之下，只在路径段的边界上匹配
+func matchPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return strings.HasSuffix(prefix, "/") && path == strings.TrimSuffix(prefix, "/")
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// Handler 返回限流之后再调用 next 的中间件，可以与 withRequestID 等中间件任意组合
+func (h *HTTPRateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rl := h.match(r)
+		ok, state := rl.keys.allowN(h.keyFunc(r), 1)
+
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(int(state.burst)))
+		header.Set("RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(state.tokens)))))
+		setSeconds(header, "RateLimit-Reset", state.limit, state.burst-state.tokens)
+		if !ok {
+			setSeconds(header, "Retry-After", state.limit, 1-state.tokens)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WeightFunc 返回请求在处理期间占用的信号量许可数
+type WeightFunc func(r *http.Request) int64
+
+// WeightByContentLength 按请求体大小估算内存占用：每 unit 字节一个许可，另加处理请求本身的一个许可。
+// 请求体长度未知时只占用一个许可。权重超过信号量容量的请求会被 ConcurrencyLimiter 以 413 拒绝。
+func WeightByContentLength(unit int64) WeightFunc {
+	return func(r *http.Request) int64 {
+		if r.ContentLength <= 0 {
+			return 1
+		}
+		return 1 + (r.ContentLength+unit-1)/unit
+	}
+}
+
+// ConcurrencyLimiter 是限制在途请求工作量的 HTTP 中间件。与按速率限流的 HTTPRateLimiter 不同，
+// 它在请求处理期间占用 Semaphore 的许可，处理结束后归还；sem 可以与 WorkerPool 共享同一个上限。
+// 在 maxWait 内拿不到许可的请求返回 503 和 Retry-After；权重超过容量、永远拿不到许可的请求返回 413。
+type ConcurrencyLimiter struct {
+	sem     *Semaphore
+	weight  WeightFunc
+	maxWait time.Duration
+}
+
+// NewConcurrencyLimiter 创建并发上限中间件。weight 为 nil 时每个请求占用一个许可；
+// maxWait 为 0 时拿不到许可立即拒绝，否则最多排队 maxWait。
+func NewConcurrencyLimiter(sem *Semaphore, weight WeightFunc, maxWait time.Duration) *ConcurrencyLimiter {
+	if weight == nil {
+		weight = func(*http.Request) int64 { return 1 }
+	}
+	return &ConcurrencyLimiter{sem: sem, weight: weight, maxWait: maxWait}
+}
+
+// Handler 返回在持有许可期间调用 next 的中间件
+func (c *ConcurrencyLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := c.weight(r)
+		if n > c.sem.Size() {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !c.acquire(r.Context(), n) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer c.sem.Release(n)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire 在 maxWait 内获取 n 个许可
+func (c *ConcurrencyLimiter) acquire(ctx context.Context, n int64) bool {
+	if c.maxWait <= 0 {
+		return c.sem.TryAcquire(n)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.maxWait)
+	defer cancel()
+	return c.sem.Acquire(ctx, n) == nil
+}
+
+// setSeconds 把按 limit 生成 tokens 个令牌需要的秒数写入响应头 key，最多 maxRetryAfter。
+// limit 不大于 0 时令牌永远不会恢复，不写这个头，客户端无论等多久都一样会被拒绝。
+func setSeconds(header http.Header, key string, limit Limit, tokens float64) {
+	if limit <= 0 {
+		return
+	}
+	d := min(limit.durationFromTokens(tokens), maxRetryAfter)
+	header.Set(key, strconv.FormatInt(ceilSeconds(d), 10))
+}
+
+// ceilSeconds 把时长向上取整为秒，用于 Retry-After 等只支持整秒的响应头
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
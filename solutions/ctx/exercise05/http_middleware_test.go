@@ -0,0 +1,261 @@
+package exercise05
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve 向 handler 发送一个请求并返回响应
+func serve(h http.Handler, method, path, remoteAddr string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	r.RemoteAddr = remoteAddr
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// TestHTTPRateLimiter_Headers tests the 429 response and the RateLimit headers on every response.
+func TestHTTPRateLimiter_Headers(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	h := NewHTTPRateLimiter(Per(3, time.Minute), 3, nil, WithClock(clock)).Handler(okHandler)
+
+	for i := 0; i < 3; i++ {
+		w := serve(h, http.MethodGet, "/", "10.0.0.1:1234", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, w.Code)
+		}
+		if got, want := w.Header().Get("RateLimit-Remaining"), []string{"2", "1", "0"}[i]; got != want {
+			t.Errorf("request %d: expected RateLimit-Remaining %s, got %s", i, want, got)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "3" {
+			t.Errorf("request %d: expected RateLimit-Limit 3, got %s", i, got)
+		}
+	}
+
+	w := serve(h, http.MethodGet, "/", "10.0.0.1:1234", nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	// 每分钟 3 个，下一个令牌 20 秒后到达，桶完全恢复需要 60 秒
+	if got := w.Header().Get("Retry-After"); got != "20" {
+		t.Errorf("expected Retry-After 20, got %q", got)
+	}
+	if got := w.Header().Get("RateLimit-Reset"); got != "60" {
+		t.Errorf("expected RateLimit-Reset 60, got %q", got)
+	}
+
+	clock.Advance(20 * time.Second)
+	if w := serve(h, http.MethodGet, "/", "10.0.0.1:1234", nil); w.Code != http.StatusOK {
+		t.Errorf("expected 200 after Retry-After, got %d", w.Code)
+	}
+}
+
+// TestHTTPRateLimiter_SlowRefill is a regression test: a zero or near-zero limit used to
+// produce a Retry-After of about 9.2e9 seconds.
+func TestHTTPRateLimiter_SlowRefill(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit Limit
+		want  string // Retry-After 和 RateLimit-Reset 的期望值，空表示不发送
+	}{
+		{"zero limit never refills", 0, ""},
+		{"near-zero limit is capped", Limit(1e-12), "3600"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(time.Unix(0, 0))
+			h := NewHTTPRateLimiter(tt.limit, 1, nil, WithClock(clock)).Handler(okHandler)
+			if w := serve(h, http.MethodGet, "/", "10.0.0.1:1234", nil); w.Code != http.StatusOK {
+				t.Fatalf("expected the burst to allow the first request, got %d", w.Code)
+			}
+			w := serve(h, http.MethodGet, "/", "10.0.0.1:1234", nil)
+			if w.Code != http.StatusTooManyRequests {
+				t.Fatalf("expected 429, got %d", w.Code)
+			}
+			for _, key := range []string{"Retry-After", "RateLimit-Reset"} {
+				if got := w.Header().Values(key); tt.want == "" && len(got) != 0 || tt.want != "" && (len(got) != 1 || got[0] != tt.want) {
+					t.Errorf("expected %s %q, got %q", key, tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+// TestHTTPRateLimiter_Keys tests limiting by client IP and by API key.
+func TestHTTPRateLimiter_Keys(t *testing.T) {
+	t.Run("by IP", func(t *testing.T) {
+		h := NewHTTPRateLimiter(Every(time.Hour), 1, KeyByIP).Handler(okHandler)
+		if w := serve(h, http.MethodGet, "/", "10.0.0.1:1", nil); w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		if w := serve(h, http.MethodGet, "/", "10.0.0.1:2", nil); w.Code != http.StatusTooManyRequests {
+			t.Errorf("expected a second connection from the same IP to be limited, got %d", w.Code)
+		}
+		if w := serve(h, http.MethodGet, "/", "10.0.0.2:1", nil); w.Code != http.StatusOK {
+			t.Errorf("expected another IP to have its own bucket, got %d", w.Code)
+		}
+	})
+
+	t.Run("by API key", func(t *testing.T) {
+		h := NewHTTPRateLimiter(Every(time.Hour), 1, KeyByHeader("X-API-Key")).Handler(okHandler)
+		keyA := http.Header{"X-Api-Key": {"a"}}
+		keyB := http.Header{"X-Api-Key": {"b"}}
+		if w := serve(h, http.MethodGet, "/", "10.0.0.1:1", keyA); w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		if w := serve(h, http.MethodGet, "/", "10.0.0.2:1", keyA); w.Code != http.StatusTooManyRequests {
+			t.Errorf("expected the same API key from another IP to be limited, got %d", w.Code)
+		}
+		if w := serve(h, http.MethodGet, "/", "10.0.0.1:1", keyB); w.Code != http.StatusOK {
+			t.Errorf("expected another API key to have its own bucket, got %d", w.Code)
+		}
+	})
+
+	t.Run("by validated API key", func(t *testing.T) {
+		valid := func(key string) bool { return key == "a" }
+		h := NewHTTPRateLimiter(Every(time.Hour), 1, KeyByAPIKey("X-API-Key", valid)).Handler(okHandler)
+		if w := serve(h, http.MethodGet, "/", "10.0.0.1:1", http.Header{"X-Api-Key": {"a"}}); w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		if w := serve(h, http.MethodGet, "/", "10.0.0.1:1", http.Header{"X-Api-Key": {"forged-1"}}); w.Code != http.StatusOK {
+			t.Fatalf("expected an unknown key to use the IP's bucket, got %d", w.Code)
+		}
+		if w := serve(h, http.MethodGet, "/", "10.0.0.1:1", http.Header{"X-Api-Key": {"forged-2"}}); w.Code != http.StatusTooManyRequests {
+			t.Errorf("expected a new forged key not to get a fresh bucket, got %d", w.Code)
+		}
+	})
+
+	t.Run("custom key", func(t *testing.T) {
+		tenant := func(r *http.Request) string { return r.URL.Query().Get("tenant") }
+		h := NewHTTPRateLimiter(Every(time.Hour), 1, tenant).Handler(okHandler)
+		serve(h, http.MethodGet, "/?tenant=acme", "10.0.0.1:1", nil)
+		if w := serve(h, http.MethodGet, "/?tenant=acme", "10.0.0.2:1", nil); w.Code != http.StatusTooManyRequests {
+			t.Errorf("expected the tenant to be limited across IPs, got %d", w.Code)
+		}
+	})
+}
+
+// TestHTTPRateLimiter_Routes tests route-specific limits.
+func TestHTTPRateLimiter_Routes(t *testing.T) {
+	h := NewHTTPRateLimiter(Every(time.Hour), 5, nil).
+		Route("/api/", Every(time.Hour), 2).
+		Route("POST /api/upload", Every(time.Hour), 1).
+		Handler(okHandler)
+
+	codes := func(method, path string, n int) []int {
+		var res []int
+		for i := 0; i < n; i++ {
+			res = append(res, serve(h, method, path, "10.0.0.1:1", nil).Code)
+		}
+		return res
+	}
+
+	if got := codes(http.MethodPost, "/api/upload", 2); got[0] != 200 || got[1] != 429 {
+		t.Errorf("expected POST /api/upload to allow 1, got %v", got)
+	}
+	// GET 不匹配 POST 路由，落到 /api/ 的限额上
+	if got := codes(http.MethodGet, "/api/upload", 3); got[0] != 200 || got[1] != 200 || got[2] != 429 {
+		t.Errorf("expected GET /api/upload to use the /api/ limit of 2, got %v", got)
+	}
+	if got := codes(http.MethodGet, "/", 6); got[4] != 200 || got[5] != 429 {
+		t.Errorf("expected other routes to use the default limit of 5, got %v", got)
+	}
+}
+
+// TestHTTPRateLimiter_RoutePrecedence tests that a method-specific route wins over a route
+// with the same prefix registered earlier, and that prefixes match whole path segments.
+func TestHTTPRateLimiter_RoutePrecedence(t *testing.T) {
+	h := NewHTTPRateLimiter(Every(time.Hour), 1000, nil).
+		Route("/upload/", Every(time.Hour), 100).
+		Route("POST /upload/", Every(time.Hour), 1).
+		Route("/api", Every(time.Hour), 2).
+		Handler(okHandler)
+
+	for i, want := range []int{200, 429, 429} {
+		w := serve(h, http.MethodPost, "/upload/file", "10.0.0.1:1", nil)
+		if w.Code != want {
+			t.Errorf("POST %d: expected %d, got %d", i, want, w.Code)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "1" {
+			t.Errorf("POST %d: expected the POST route's limit of 1, got %s", i, got)
+		}
+	}
+
+	for path, want := range map[string]string{"/api": "2", "/api/users": "2", "/apiary": "1000", "/upload": "100"} {
+		if got := serve(h, http.MethodGet, path, "10.0.0.2:1", nil).Header().Get("RateLimit-Limit"); got != want {
+			t.Errorf("GET %s: expected RateLimit-Limit %s, got %s", path, want, got)
+		}
+	}
+}
+
+// TestConcurrencyLimiter tests that requests beyond the in-flight cap are rejected with 503.
+func TestConcurrencyLimiter(t *testing.T) {
+	sem := NewSemaphore(2)
+	entered, release := make(chan struct{}), make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+	h := NewConcurrencyLimiter(sem, nil, 0).Handler(slow)
+
+	results := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() { results <- serve(h, "GET", "/", "1.1.1.1:1", nil).Code }()
+		<-entered
+	}
+
+	w := serve(h, "GET", "/", "1.1.1.1:1", nil)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("third request: got %d with Retry-After %q, want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if code := <-results; code != http.StatusOK {
+			t.Errorf("in-flight request: got %d, want 200", code)
+		}
+	}
+	if got := sem.InUse(); got != 0 {
+		t.Errorf("expected all permits released, %d still in use", got)
+	}
+
+	// 带 maxWait 时按请求体大小占用许可，许可被释放后排队的请求可以通过
+	weighted := NewConcurrencyLimiter(sem, WeightByContentLength(1024), time.Second).Handler(okHandler)
+	r := httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("x", 100)))
+	if got := WeightByContentLength(1024)(r); got != 2 {
+		t.Errorf("weight for a 100 byte body = %d, want 2", got)
+	}
+	sem.TryAcquire(1)
+	go func() {
+		for semQueued(sem) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		sem.Release(1)
+	}()
+	rec := httptest.NewRecorder()
+	weighted.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Errorf("queued weighted request: got %d, want 200", rec.Code)
+	}
+
+	// 权重超过容量的请求永远拿不到许可，直接返回 413 而不是堵住队列
+	large := httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("x", 4096)))
+	rec = httptest.NewRecorder()
+	weighted.ServeHTTP(rec, large)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized request: got %d, want 413", rec.Code)
+	}
+	if !sem.TryAcquire(2) {
+		t.Error("expected the semaphore to stay usable after an oversized request")
+	}
+}
@@ -0,0 +1,208 @@
+package exercise05
+
+import (
+	"container/list"
+	"context"
+	"hash/maphash"
+	"sync"
+)
+
+const (
+	// maxKeyedShards 是 KeyedLimiter 的最大分片数
+	maxKeyedShards = 64
+	// minKeysPerShard 是每个分片至少容纳的 key 数，key 上限较小时减少分片，保证 LRU 上限足够精确
+	minKeysPerShard = 1024
+	// evictIdlePerInsert 是每次插入新 key 时顺带检查的 LRU 尾部条目数，用来摊还空闲条目的清理
+	evictIdlePerInsert = 2
+	// evictScanLimit 是 key 数量达到上限时，为腾出位置最多检查的 LRU 尾部条目数
+	evictScanLimit = 8
+)
+
+// KeyedLimiter 为每个 key 维护一个独立的令牌桶，例如按用户限流。
+// 令牌桶在第一次使用时按统一的速率和突发量创建；空闲（已经满了、无人等待且没有调用正在使用）的令牌桶
+// 与新建的等价，会在插入新 key 时或 EvictIdle 中被回收；key 的总数达到上限时按 LRU 淘汰空闲的令牌桶。
+// 不空闲的令牌桶永远不会被淘汰，否则同一个 key 会拿到一个新的满桶而突破限额，
+// 因此上限是软性的：短时间内仍在受限的 key 超过上限时，key 的数量会暂时超过上限。
+// 内部按 key 的哈希分片，每个分片一把锁，避免所有请求争抢同一把全局锁。
+type KeyedLimiter struct {
+	limit  Limit
+	burst  int
+	clock  Clock
+	seed   maphash.Seed
+	shards []*keyedShard
+}
+
+// keyedShard 是 KeyedLimiter 的一个分片
+type keyedShard struct {
+	mu      sync.Mutex
+	maxKeys int // 0 表示不限制
+	entries map[string]*list.Element
+	lru     list.List // 最近使用的在前面，元素为 *keyedEntry
+}
+
+// keyedEntry 把令牌桶直接嵌入 LRU 条目中，每个 key 少一次内存分配
+type keyedEntry struct {
+	key    string
+	refs   int // 正在使用令牌桶的调用数，受分片的锁保护，不为 0 时不会被回收
+	bucket TokenBucket
+}
+
+// evictable 判断条目能否被回收，调用方需持有分片的锁
+func (e *keyedEntry) evictable() bool {
+	return e.refs == 0 && e.bucket.idle()
+}
+
+// NewKeyedLimiter 创建一个按 key 限流的限流器，每个 key 的速率为 limit、突发量为 burst，
+// 最多同时保存 maxKeys 个 key，maxKeys <= 0 表示不限制（仍然会回收空闲的 key）。
+func NewKeyedLimiter(limit Limit, burst int, maxKeys int, opts ...Option) *KeyedLimiter {
+	numShards := 1
+	if maxKeys <= 0 {
+		numShards = maxKeyedShards
+	}
+	for numShards < maxKeyedShards && maxKeys/(numShards*2) >= minKeysPerShard {
+		numShards *= 2
+	}
+
+	k := &KeyedLimiter{
+		limit:  limit,
+		burst:  burst,
+		clock:  applyOptions(opts).clock,
+		seed:   maphash.MakeSeed(),
+		shards: make([]*keyedShard, numShards),
+	}
+	for i := range k.shards {
+		k.shards[i] = &keyedShard{maxKeys: max(maxKeys, 0) / numShards, entries: make(map[string]*list.Element)}
+	}
+	return k
+}
+
+// shard 返回 key 所在的分片
+func (k *KeyedLimiter) shard(key string) *keyedShard {
+	return k.shards[maphash.String(k.seed, key)%uint64(len(k.shards))]
+}
+
+// entryLocked 返回 key 对应的条目，不存在时创建，并把它标记为最近使用，调用方需持有分片的锁
+func (s *keyedShard) entryLocked(k *KeyedLimiter, key string) *keyedEntry {
+	if elem, ok := s.entries[key]; ok {
+		s.lru.MoveToFront(elem)
+		return elem.Value.(*keyedEntry)
+	}
+
+	// 顺带回收 LRU 尾部的空闲条目，再按上限淘汰最久未使用的空闲条目
+	for i := 0; i < evictIdlePerInsert; i++ {
+		back := s.lru.Back()
+		if back == nil || !back.Value.(*keyedEntry).evictable() {
+			break
+		}
+		s.removeLocked(back)
+	}
+	if s.maxKeys > 0 {
+		elem := s.lru.Back()
+		for i := 0; elem != nil && i < evictScanLimit && s.lru.Len() >= s.maxKeys; i++ {
+			prev := elem.Prev()
+			if elem.Value.(*keyedEntry).evictable() {
+				s.removeLocked(elem)
+			}
+			elem = prev
+		}
+	}
+
+	entry := &keyedEntry{key: key}
+	entry.bucket.init(k.limit, k.burst, k.clock)
+	s.entries[key] = s.lru.PushFront(entry)
+	return entry
+}
+
+// acquire 返回 key 对应的条目并增加引用计数，在调用 release 之前条目不会被回收，
+// 令牌桶始终是 key 在表中对应的那一个
+func (k *KeyedLimiter) acquire(key string) (*keyedShard, *keyedEntry) {
+	s := k.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entryLocked(k, key)
+	entry.refs++
+	return s, entry
+}
+
+// release 归还 acquire 获得的条目
+func (s *keyedShard) release(entry *keyedEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.refs--
+}
+
+// allowN 在分片的锁内对 key 的令牌桶调用 allowN，判断期间条目不会被回收
+func (k *KeyedLimiter) allowN(key string, n int) (bool, bucketState) {
+	s := k.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entryLocked(k, key).bucket.allowN(n)
+}
+
+// Bucket 返回 key 对应的令牌桶，不存在时创建，例如用来组合成 Level 做一次分层检查。
+// 调用方用完后必须调用 release；在此之前令牌桶不会被回收，release 之后不应再使用它。
+func (k *KeyedLimiter) Bucket(key string) (tb *TokenBucket, release func()) {
+	s, entry := k.acquire(key)
+	var once sync.Once
+	return &entry.bucket, func() { once.Do(func() { s.release(entry) }) }
+}
+
+// removeLocked 删除一个条目，调用方需持有分片的锁
+func (s *keyedShard) removeLocked(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.entries, elem.Value.(*keyedEntry).key)
+}
+
+// Allow 是 AllowN(key, 1) 的简写
+func (k *KeyedLimiter) Allow(key string) bool {
+	return k.AllowN(key, 1)
+}
+
+// AllowN 尝试立即从 key 的令牌桶中取走 n 个令牌
+func (k *KeyedLimiter) AllowN(key string, n int) bool {
+	ok, _ := k.allowN(key, n)
+	return ok
+}
+
+// Wait 是 WaitN(ctx, key, 1) 的简写
+func (k *KeyedLimiter) Wait(ctx context.Context, key string) error {
+	return k.WaitN(ctx, key, 1)
+}
+
+// WaitN 阻塞直到从 key 的令牌桶中取走 n 个令牌，或者 ctx 结束。
+// 有人等待的令牌桶不是空闲的，不会被 EvictIdle 回收。
+func (k *KeyedLimiter) WaitN(ctx context.Context, key string, n int) error {
+	s, entry := k.acquire(key)
+	defer s.release(entry)
+	return entry.bucket.WaitN(ctx, n)
+}
+
+// Len 返回当前保存的 key 数量
+func (k *KeyedLimiter) Len() int {
+	n := 0
+	for _, s := range k.shards {
+		s.mu.Lock()
+		n += s.lru.Len()
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// EvictIdle 回收所有空闲的令牌桶并返回回收的数量。可以由调用方用 time.Ticker 定期调用，
+// 让长期不活跃的 key 及时释放内存，而不必等到插入新 key 或触发 LRU 上限。
+func (k *KeyedLimiter) EvictIdle() int {
+	evicted := 0
+	for _, s := range k.shards {
+		s.mu.Lock()
+		for elem := s.lru.Back(); elem != nil; {
+			prev := elem.Prev()
+			if elem.Value.(*keyedEntry).evictable() {
+				s.removeLocked(elem)
+				evicted++
+			}
+			elem = prev
+		}
+		s.mu.Unlock()
+	}
+	return evicted
+}
@@ -0,0 +1,225 @@
+package exercise05
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestKeyedLimiter_PerKey tests that each key gets its own lazily created bucket.
+func TestKeyedLimiter_PerKey(t *testing.T) {
+	k := NewKeyedLimiter(Every(time.Hour), 2, 0)
+
+	if !k.Allow("alice") || !k.Allow("alice") {
+		t.Fatal("expected alice's burst of 2 to be allowed")
+	}
+	if k.Allow("alice") {
+		t.Error("expected alice to be limited after her burst")
+	}
+	if !k.Allow("bob") {
+		t.Error("expected bob to have his own bucket")
+	}
+	if got := k.Len(); got != 2 {
+		t.Errorf("expected 2 keys, got %d", got)
+	}
+}
+
+// TestKeyedLimiter_EvictIdle tests that only full buckets without waiters are evicted.
+func TestKeyedLimiter_EvictIdle(t *testing.T) {
+	t.Run("should evict buckets that have refilled", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		k := NewKeyedLimiter(10, 10, 0, WithClock(clock))
+
+		k.AllowN("a", 10)
+		k.AllowN("b", 1)
+		if got := k.EvictIdle(); got != 0 {
+			t.Fatalf("expected no idle buckets yet, evicted %d", got)
+		}
+
+		// b 在 100ms 后补满，a 需要 1s
+		clock.Advance(100 * time.Millisecond)
+		if got := k.EvictIdle(); got != 1 {
+			t.Errorf("expected only b to be evicted, evicted %d", got)
+		}
+		clock.Advance(900 * time.Millisecond)
+		if got := k.EvictIdle(); got != 1 || k.Len() != 0 {
+			t.Errorf("expected a to be evicted once full, evicted %d, %d keys left", got, k.Len())
+		}
+
+		// 被回收的 key 再次出现时得到一个新的满桶，与没有回收时行为一致
+		if !k.AllowN("a", 10) {
+			t.Error("expected a recreated bucket to start full")
+		}
+	})
+
+	t.Run("should keep buckets with waiters", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		k := NewKeyedLimiter(Every(time.Hour), 1, 0, WithClock(clock))
+		k.Allow("a")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- k.Wait(ctx, "a") }()
+		bucket, release := k.Bucket("a")
+		eventuallyQueued(t, bucket)
+		release()
+
+		if got := k.EvictIdle(); got != 0 {
+			t.Errorf("expected a bucket with a waiter to be kept, evicted %d", got)
+		}
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("inserting new keys reclaims idle ones", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		k := NewKeyedLimiter(10, 1, 0, WithClock(clock))
+		for i := 0; i < 100; i++ {
+			k.Allow(strconv.Itoa(i))
+		}
+		clock.Advance(time.Second)
+		for i := 100; i < 200; i++ {
+			k.Allow(strconv.Itoa(i))
+		}
+		if got := k.Len(); got >= 200 {
+			t.Errorf("expected idle keys to be reclaimed while inserting, still have %d", got)
+		}
+	})
+}
+
+// eventuallyQueued 等待直到令牌桶中有一个等待者
+func eventuallyQueued(t *testing.T, tb *TokenBucket) {
+	t.Helper()
+	waitQueued(t, tb, 1)
+}
+
+// TestKeyedLimiter_LRU tests the cap on the number of keys.
+func TestKeyedLimiter_LRU(t *testing.T) {
+	t.Run("should evict the least recently used idle key", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		k := NewKeyedLimiter(10, 1, 3, WithClock(clock))
+
+		k.Allow("a")
+		k.Allow("b")
+		k.Allow("c")
+		clock.Advance(100 * time.Millisecond) // 三个令牌桶都已补满
+		k.Allow("a")                          // a 和 c 成为最近使用的 key，且不再空闲
+		k.Allow("c")
+		k.Allow("d") // 淘汰唯一空闲的 key b
+
+		if got := k.Len(); got != 3 {
+			t.Fatalf("expected the cap of 3 keys, got %d", got)
+		}
+		if k.Allow("a") || k.Allow("c") {
+			t.Error("expected a and c to keep their exhausted buckets")
+		}
+	})
+
+	// 回归测试：淘汰仍在受限的令牌桶会让同一个 key 拿到新的满桶，热点 key 因此突破限额
+	t.Run("should never evict a limited key", func(t *testing.T) {
+		k := NewKeyedLimiter(Every(time.Hour), 1, 3)
+		if !k.Allow("hot") {
+			t.Fatal("expected the first request to be allowed")
+		}
+		for i := 0; i < 100; i++ {
+			k.Allow(strconv.Itoa(i))
+			if k.Allow("hot") {
+				t.Fatalf("hot key exceeded its limit after %d other keys", i+1)
+			}
+		}
+	})
+
+	t.Run("should not evict a bucket that is in use", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		k := NewKeyedLimiter(10, 1, 1, WithClock(clock))
+		bucket, release := k.Bucket("a")
+		k.Allow("b")
+		if !bucket.Allow() {
+			t.Fatal("expected the pinned bucket to start full")
+		}
+		if k.Allow("a") {
+			t.Error("expected a to still use the pinned bucket after another key was inserted")
+		}
+		release()
+		release() // 重复调用是安全的
+
+		clock.Advance(time.Second)
+		if got := k.EvictIdle(); got != 2 {
+			t.Errorf("expected both idle keys to be evicted once released, evicted %d", got)
+		}
+	})
+}
+
+// BenchmarkKeyedLimiter_Allow measures Allow across a million active keys from many goroutines.
+func BenchmarkKeyedLimiter_Allow(b *testing.B) {
+	const numKeys = 1_000_000
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = "user:" + strconv.Itoa(i)
+	}
+	k := NewKeyedLimiter(Every(time.Hour), 100, numKeys)
+	for _, key := range keys {
+		k.Allow(key)
+	}
+
+	var next uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddUint64(&next, 7919) % numKeys
+			k.Allow(keys[i])
+		}
+	})
+}
+
+// BenchmarkKeyedLimiter_Churn measures inserting a million distinct keys into a limiter capped at 100k,
+// so that almost every call evicts a key.
+func BenchmarkKeyedLimiter_Churn(b *testing.B) {
+	const numKeys = 1_000_000
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = "user:" + strconv.Itoa(i)
+	}
+	// 每个 key 只取一个令牌，1ms 后就恢复空闲，可以被淘汰
+	k := NewKeyedLimiter(1000, 100, 100_000)
+
+	var next uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddUint64(&next, 1) % numKeys
+			k.Allow(keys[i])
+		}
+	})
+}
+
+// BenchmarkKeyedLimiter_Memory reports the heap used per key when tracking a million keys.
+func BenchmarkKeyedLimiter_Memory(b *testing.B) {
+	const numKeys = 1_000_000
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = "user:" + strconv.Itoa(i)
+	}
+
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		k := NewKeyedLimiter(Every(time.Hour), 100, numKeys)
+		for _, key := range keys {
+			k.Allow(key)
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/numKeys, "bytes/key")
+		runtime.KeepAlive(k)
+	}
+}
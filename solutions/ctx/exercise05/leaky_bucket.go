@@ -0,0 +1,98 @@
+package exercise05
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LeakyBucket 实现了漏桶算法：请求以固定的间隔匀速流出，不允许突发。
+// Wait 会为每个请求预订一个发送时间片，最多允许 capacity 个请求在桶中排队。
+type LeakyBucket struct {
+	limit    Limit
+	interval time.Duration // 相邻两次放行之间的间隔
+	capacity int           // 桶中最多排队的请求数
+	next     time.Time     // 下一个请求最早可以放行的时间
+	clock    Clock
+	mu       sync.Mutex
+}
+
+// NewLeakyBucket 创建一个以 limit 速率匀速放行、最多排队 capacity 个请求的漏桶
+func NewLeakyBucket(limit Limit, capacity int, opts ...Option) *LeakyBucket {
+	return &LeakyBucket{
+		limit:    limit,
+		interval: limit.durationFromTokens(1),
+		capacity: capacity,
+		clock:    applyOptions(opts).clock,
+	}
+}
+
+// Allow 仅在距离上一次放行已经过了一个完整间隔时放行
+func (lb *LeakyBucket) Allow() bool {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if lb.limit == Inf {
+		return true
+	}
+	if lb.limit <= 0 {
+		return false
+	}
+	now := lb.clock.Now()
+	if now.Before(lb.next) {
+		return false
+	}
+	lb.next = now.Add(lb.interval)
+	return true
+}
+
+// Wait 为请求预订下一个空闲的时间片并等待到该时刻。
+// 排队的请求已满 capacity 个时返回 ErrQueueFull；等待期间被取消时，如果后面没有人预订，会把时间片让出来。
+func (lb *LeakyBucket) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	lb.mu.Lock()
+	if lb.limit == Inf {
+		lb.mu.Unlock()
+		return nil
+	}
+	if lb.limit <= 0 {
+		lb.mu.Unlock()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	now := lb.clock.Now()
+	slot := lb.next
+	if slot.Before(now) {
+		slot = now
+	}
+	delay := slot.Sub(now)
+	if delay > time.Duration(lb.capacity)*lb.interval {
+		lb.mu.Unlock()
+		return ErrQueueFull
+	}
+	lb.next = slot.Add(lb.interval)
+	lb.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := lb.clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		lb.mu.Lock()
+		if lb.next.Equal(slot.Add(lb.interval)) {
+			lb.next = slot
+		}
+		lb.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,63 @@
+package exercise05
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// Limiter 是速率限制算法的统一接口，WorkerPool 通过它获取执行许可。
+// 不同算法在突发、平滑程度和内存占用上各有取舍，可以在相同流量下对比选择。
+type Limiter interface {
+	// Allow 非阻塞地判断这次请求能否立即放行，放行时会计入限额
+	Allow() bool
+	// Wait 阻塞直到请求被放行，或者 ctx 结束时返回 ctx.Err()
+	Wait(ctx context.Context) error
+}
+
+var (
+	_ Limiter = (*TokenBucket)(nil)
+	_ Limiter = (*LeakyBucket)(nil)
+	_ Limiter = (*SlidingWindowLog)(nil)
+	_ Limiter = (*SlidingWindowCounter)(nil)
+	_ Limiter = (*FixedWindow)(nil)
+	_ Limiter = (*GCRA)(nil)
+	_ Limiter = (*StoreLimiter)(nil)
+	_ Limiter = (*HierarchicalLimiter)(nil)
+)
+
+// ErrQueueFull 表示排队等待的请求已经超过限流器允许的长度
+var ErrQueueFull = errors.New("limiter: wait queue is full")
+
+// never 表示永远等不到许可，只能等待 ctx 结束
+const never = time.Duration(math.MaxInt64)
+
+// waitUntilAllowed 反复调用 try 直到请求被放行。try 返回是否放行以及下次重试前需要等待的时间，
+// 等待时间为 never 时不创建定时器，只等待 ctx 结束。
+func waitUntilAllowed(ctx context.Context, clock Clock, try func(now time.Time) (bool, time.Duration)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		ok, retryAfter := try(clock.Now())
+		if ok {
+			return nil
+		}
+		if retryAfter == never {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		timer := clock.NewTimer(retryAfter)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
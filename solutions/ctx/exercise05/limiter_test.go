@@ -0,0 +1,252 @@
+package exercise05
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClock 创建一个对齐到整秒的手动时钟，固定窗口类算法的窗口边界因此是确定的
+func newTestClock() *ManualClock {
+	return NewManualClock(time.Unix(1_700_000_000, 0))
+}
+
+// limiterCase 描述一个参与一致性测试的限流算法，所有算法都配置为“每秒 10 个”
+type limiterCase struct {
+	name string
+	// burst 是新建的限流器在同一时刻最多放行的请求数
+	burst int
+	new   func(clock Clock) Limiter
+}
+
+func limiterCases() []limiterCase {
+	return []limiterCase{
+		{"TokenBucket", 10, func(clock Clock) Limiter {
+			return NewTokenBucketWithBurst(10, 10, WithClock(clock))
+		}},
+		{"LeakyBucket", 1, func(clock Clock) Limiter {
+			return NewLeakyBucket(10, 10, WithClock(clock))
+		}},
+		{"SlidingWindowLog", 10, func(clock Clock) Limiter {
+			return NewSlidingWindowLog(10, time.Second, WithClock(clock))
+		}},
+		{"SlidingWindowCounter", 10, func(clock Clock) Limiter {
+			return NewSlidingWindowCounter(10, time.Second, WithClock(clock))
+		}},
+		{"FixedWindow", 10, func(clock Clock) Limiter {
+			return NewFixedWindow(10, time.Second, WithClock(clock))
+		}},
+		{"GCRA", 10, func(clock Clock) Limiter {
+			return NewGCRA(10, 10, WithClock(clock))
+		}},
+	}
+}
+
+// replay 按 interval 的间隔向限流器发送 n 个请求，返回放行的数量
+func replay(clock *ManualClock, l Limiter, n int, interval time.Duration) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if l.Allow() {
+			allowed++
+		}
+		clock.Advance(interval)
+	}
+	return allowed
+}
+
+// TestLimiter_Conformance runs every algorithm through the same traffic on a fake clock.
+func TestLimiter_Conformance(t *testing.T) {
+	for _, tc := range limiterCases() {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Run("initial burst", func(t *testing.T) {
+				clock := newTestClock()
+				l := tc.new(clock)
+				if got := replay(clock, l, 100, 0); got != tc.burst {
+					t.Errorf("expected %d requests allowed at the same instant, got %d", tc.burst, got)
+				}
+			})
+
+			t.Run("long-run rate", func(t *testing.T) {
+				// 以 100/s 的速度持续发送 10 秒，放行数应接近 10/s * 10s，且不超过突发量加上速率。
+				// 滑动窗口计数器是近似算法，持续过载时会略少放行一些，所以下限留出 10% 的余量。
+				clock := newTestClock()
+				l := tc.new(clock)
+				got := replay(clock, l, 1000, 10*time.Millisecond)
+				if got < 90 || got > 100+tc.burst {
+					t.Errorf("expected between 90 and %d allowed over 10s, got %d", 100+tc.burst, got)
+				}
+			})
+
+			t.Run("recovers after idle", func(t *testing.T) {
+				clock := newTestClock()
+				l := tc.new(clock)
+				replay(clock, l, 100, 0)
+				if l.Allow() {
+					t.Fatal("expected an exhausted limiter to reject")
+				}
+				clock.Advance(2 * time.Second)
+				if got := replay(clock, l, 100, 0); got != tc.burst {
+					t.Errorf("expected %d allowed after idling, got %d", tc.burst, got)
+				}
+			})
+
+			t.Run("wait blocks until the clock advances", func(t *testing.T) {
+				clock := newTestClock()
+				l := tc.new(clock)
+				for l.Allow() {
+				}
+
+				done := make(chan error, 1)
+				go func() { done <- l.Wait(context.Background()) }()
+				clock.BlockUntil(1)
+				select {
+				case err := <-done:
+					t.Fatalf("expected Wait to block on an exhausted limiter, returned %v", err)
+				default:
+				}
+
+				// 有的算法醒来后需要再等一轮，按 100ms 一步推进，直到 Wait 返回
+				start := clock.Now()
+				for waiting := true; waiting; {
+					select {
+					case err := <-done:
+						if err != nil {
+							t.Errorf("unexpected error: %v", err)
+						}
+						waiting = false
+					default:
+						if clock.Timers() == 0 {
+							time.Sleep(time.Millisecond)
+							continue
+						}
+						clock.Advance(100 * time.Millisecond)
+					}
+				}
+				if elapsed := clock.Now().Sub(start); elapsed > 2*time.Second {
+					t.Errorf("expected a permit within 2s of virtual time, took %v", elapsed)
+				}
+			})
+
+			t.Run("wait respects ctx", func(t *testing.T) {
+				clock := newTestClock()
+				l := tc.new(clock)
+				for l.Allow() {
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan error, 1)
+				go func() { done <- l.Wait(ctx) }()
+				clock.BlockUntil(1)
+				cancel()
+				if err := <-done; !errors.Is(err, context.Canceled) {
+					t.Errorf("expected context.Canceled, got %v", err)
+				}
+			})
+		})
+	}
+}
+
+// TestLimiter_SlidingWindowLogIsExact tests that no window of one second ever sees more than the limit,
+// which is the property fixed windows give up at their boundaries.
+func TestLimiter_SlidingWindowLogIsExact(t *testing.T) {
+	clock := newTestClock()
+	clock.Advance(900 * time.Millisecond) // 从窗口末尾开始，制造边界突发
+	sw := NewSlidingWindowLog(10, time.Second, WithClock(clock))
+	fw := NewFixedWindow(10, time.Second, WithClock(clock))
+
+	var swTimes, fwTimes []time.Time
+	for i := 0; i < 40; i++ {
+		now := clock.Now()
+		if sw.Allow() {
+			swTimes = append(swTimes, now)
+		}
+		if fw.Allow() {
+			fwTimes = append(fwTimes, now)
+		}
+		clock.Advance(10 * time.Millisecond)
+	}
+
+	if got := maxInWindow(swTimes, time.Second); got > 10 {
+		t.Errorf("sliding window log allowed %d requests within one second", got)
+	}
+	if got := maxInWindow(fwTimes, time.Second); got != 20 {
+		t.Errorf("expected the fixed window to allow 20 across a boundary, got %d", got)
+	}
+}
+
+// maxInWindow 返回任意长度为 window 的时间段内最多出现的时间戳数量
+func maxInWindow(times []time.Time, window time.Duration) int {
+	best := 0
+	for i := range times {
+		n := 0
+		for j := i; j < len(times) && times[j].Sub(times[i]) < window; j++ {
+			n++
+		}
+		best = max(best, n)
+	}
+	return best
+}
+
+// TestLimiter_LeakyBucketPacing tests that the leaky bucket spaces requests evenly and bounds its queue.
+func TestLimiter_LeakyBucketPacing(t *testing.T) {
+	t.Run("should allow one request per interval", func(t *testing.T) {
+		clock := newTestClock()
+		lb := NewLeakyBucket(10, 0, WithClock(clock))
+		// 每 25ms 一个请求，每 100ms 只能放行一个
+		if got := replay(clock, lb, 40, 25*time.Millisecond); got != 10 {
+			t.Errorf("expected 10 evenly paced requests in one second, got %d", got)
+		}
+	})
+
+	t.Run("should reject waiters beyond capacity", func(t *testing.T) {
+		lb := NewLeakyBucket(Every(time.Hour), 0)
+		if err := lb.Wait(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := lb.Wait(context.Background()); !errors.Is(err, ErrQueueFull) {
+			t.Errorf("expected ErrQueueFull, got %v", err)
+		}
+	})
+
+	t.Run("cancelled waiters should release their slot", func(t *testing.T) {
+		clock := newTestClock()
+		lb := NewLeakyBucket(Every(time.Hour), 1, WithClock(clock))
+		if err := lb.Wait(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		next := lb.next
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- lb.Wait(ctx) }()
+		clock.BlockUntil(1)
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the 2nd request to queue until cancelled, got %v", err)
+		}
+		if !lb.next.Equal(next) {
+			t.Errorf("expected the cancelled slot to be released, next moved from %v to %v", next, lb.next)
+		}
+	})
+}
+
+// TestWorkerPool_WithLimiter tests that the pool works with any Limiter implementation.
+func TestWorkerPool_WithLimiter(t *testing.T) {
+	for _, l := range []Limiter{
+		NewGCRA(1000, 10),
+		NewSlidingWindowLog(100, 100*time.Millisecond),
+		NewLeakyBucket(1000, 10),
+	} {
+		var jobsDone int64
+		pool := NewWorkerPoolWithLimiter(context.Background(), 5, l)
+		for i := 0; i < 50; i++ {
+			pool.Submit(func() { atomic.AddInt64(&jobsDone, 1) })
+		}
+		pool.Shutdown()
+		if got := atomic.LoadInt64(&jobsDone); got != 50 {
+			t.Errorf("%T: expected 50 jobs done, got %d", l, got)
+		}
+	}
+}
@@ -1,7 +1,11 @@
-package main
+// Package exercise05 是限流库：TokenBucket 等限流器、Semaphore、WorkerPool 和 HTTP 中间件。
+// 它原来是 package main，为了让 exercise03 和 exercise09 通过 go.mod 里的
+// replace exercise05 => ../exercise05 导入而改成了库；可以运行的程序在 cmd/ratesim 和 cmd/ratestore 下。
+package exercise05
 
 import (
 	"context"
+	"errors"
 	"sync"
 )
 
@@ -31,16 +35,23 @@ Worker 从一个内部的 jobs channel 中获取任务。
 // Job 自定义任务类型
 type Job func()
 
+// weightedJob 是带权重的任务，权重是执行期间占用的信号量许可数
+type weightedJob struct {
+	job    Job
+	weight int64
+}
+
 // WorkerPool 工作池
 // 任务队列长度要足够大，否则submit会一直阻塞
 type WorkerPool struct {
 	workerCount int
-	taskChan    chan Job
-	rateChan    chan Job
+	taskChan    chan weightedJob
+	rateChan    chan weightedJob
 	ctx         context.Context
 	cancel      context.CancelFunc
 	wg          sync.WaitGroup
-	bucket      *TokenBucket // 引用独立的 TokenBucket
+	limiter     Limiter    // 限流算法，默认是独立的 TokenBucket，nil 表示不限速
+	sem         *Semaphore // 在途任务的并发上限，nil 表示不限制
 }
 
 // taskChan的长度
@@ -49,18 +60,32 @@ const (
 	// 令牌桶的最大容量可以与速率挂钩，比如允许一秒的突发量
 )
 
-// NewWorkerPool 工作池初始化函数
-func NewWorkerPool(ctx context.Context, workerCount int, ratePerSecond int) *WorkerPool {
+// NewWorkerPool 工作池初始化函数，使用每秒 ratePerSecond 个令牌的令牌桶限流。
+// opts 会传给令牌桶，例如用 WithClock 注入测试时钟。
+func NewWorkerPool(ctx context.Context, workerCount int, ratePerSecond int, opts ...Option) *WorkerPool {
+	return NewWorkerPoolWithLimiter(ctx, workerCount, NewTokenBucket(ratePerSecond, opts...))
+}
+
+// NewWorkerPoolWithLimiter 使用任意 Limiter 实现限流的工作池初始化函数
+func NewWorkerPoolWithLimiter(ctx context.Context, workerCount int, limiter Limiter) *WorkerPool {
+	return NewWorkerPoolWithLimits(ctx, workerCount, limiter, nil)
+}
+
+// NewWorkerPoolWithLimits 同时限制速率和在途工作量的工作池初始化函数。
+// 每个任务开始前从 sem 获取与其权重相同的许可，执行结束后归还；sem 可以与其他工作池或 HTTP 中间件共享。
+// limiter 或 sem 为 nil 时不做对应的限制。
+func NewWorkerPoolWithLimits(ctx context.Context, workerCount int, limiter Limiter, sem *Semaphore) *WorkerPool {
 	ctx, cancel := context.WithCancel(ctx)
 	// 初始化任务队列
 	workerPool := &WorkerPool{
 		workerCount: workerCount,
-		taskChan:    make(chan Job, taskChanCap),
-		rateChan:    make(chan Job, taskChanCap),
+		taskChan:    make(chan weightedJob, taskChanCap),
+		rateChan:    make(chan weightedJob, taskChanCap),
 		wg:          sync.WaitGroup{},
 		ctx:         ctx,
 		cancel:      cancel,
-		bucket:      NewTokenBucket(ratePerSecond),
+		limiter:     limiter,
+		sem:         sem,
 	}
 
 	// 创建一个中间chan控制速率
@@ -81,7 +106,15 @@ func NewWorkerPool(ctx context.Context, workerCount int, ratePerSecond int) *Wor
 func (w *WorkerPool) dispatcher() {
 	defer w.wg.Done()
 	// 当 dispatcher 退出时，意味着不会再有任务被分发，可以安全关闭 rateChan
-	defer close(w.rateChan)
+	defer func() {
+		close(w.rateChan)
+		if w.ctx.Err() != nil {
+			// 强制取消时 worker 不再执行 rateChan 中剩下的任务，归还它们占用的许可
+			for job := range w.rateChan {
+				w.release(job)
+			}
+		}
+	}()
 
 	for {
 		// 优先检查 context 是否被取消
@@ -94,10 +127,21 @@ func (w *WorkerPool) dispatcher() {
 				// taskChan被关闭，这是优雅关闭的信号
 				return
 			}
-			// 正常接收到任务，等待令牌
-			if err := w.bucket.WaitAndTake(w.ctx); err != nil {
-				// 在等待令牌时被强制取消
-				return
+			// 正常接收到任务，先等待在途工作量降下来，再等待令牌
+			if w.sem != nil {
+				if err := w.sem.Acquire(w.ctx, job.weight); errors.Is(err, ErrExceedsSize) {
+					// 排队期间信号量被缩容，任务再也拿不到许可，丢弃它而不是堵住后面的任务
+					continue
+				} else if err != nil {
+					return
+				}
+			}
+			if w.limiter != nil {
+				if err := w.limiter.Wait(w.ctx); err != nil {
+					// 在等待令牌时被强制取消
+					w.release(job)
+					return
+				}
 			}
 
 			// 将任务发送给 worker，同时也要能响应 shutdown 信号
@@ -105,6 +149,7 @@ func (w *WorkerPool) dispatcher() {
 			case w.rateChan <- job:
 			case <-w.ctx.Done():
 				// 在发送给 worker 时被强制取消
+				w.release(job)
 				return
 			}
 		}
@@ -125,18 +170,36 @@ func (w *WorkerPool) worker() {
 				return
 			}
 			// 执行任务
-			task()
+			task.job()
+			w.release(task)
 		}
 	}
 }
 
+// release 归还任务占用的许可
+func (w *WorkerPool) release(job weightedJob) {
+	if w.sem != nil {
+		w.sem.Release(job.weight)
+	}
+}
+
 // Submit 向工作池提交一个任务。如果任务队列已满，此方法可以阻塞。
 func (w *WorkerPool) Submit(job Job) {
+	_ = w.SubmitWeighted(job, 1)
+}
+
+// SubmitWeighted 提交一个执行期间占用 weight 个信号量许可的任务，例如按任务需要的内存设置权重。
+// weight 超过信号量容量时不提交并返回 ErrExceedsSize；任务排队期间信号量被缩容到 weight 以下时会被丢弃。
+// 工作池没有配置信号量时与 Submit 相同。
+func (w *WorkerPool) SubmitWeighted(job Job, weight int64) error {
+	if w.sem != nil && weight > w.sem.Size() {
+		return ErrExceedsSize
+	}
 	select {
 	case <-w.ctx.Done():
-		return
-	case w.taskChan <- job:
+	case w.taskChan <- weightedJob{job: job, weight: weight}:
 	}
+	return nil
 }
 
 // Shutdown 优雅地关闭工作池。它应该停止接收新任务，并等待所有已在队列中和正在执行的任务完成后再返回。
@@ -1,7 +1,8 @@
-package main
+package exercise05
 
 import (
 	"context"
+	"errors"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -40,45 +41,61 @@ func TestWorkerPool_BasicExecutionAndShutdown(t *testing.T) {
 	})
 }
 
+// eventually 轮询 cond 直到它返回 true，用于等待后台 goroutine 处理完已经放行的任务
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 5s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 // TestWorkerPool_RateLimiting tests if the rate limiting mechanism is working correctly.
 func TestWorkerPool_RateLimiting(t *testing.T) {
 	t.Run("should respect the rate limit", func(t *testing.T) {
-		// 1. 设置
+		// 1. 设置：使用手动时钟，时间只在测试推进时流逝
 		const numJobs = 50
 		const workerCount = 5
-		const rateLimit = 10 // 每秒 10 个任务
+		const rateLimit = 10 // 每秒 10 个任务，突发量同样为 10
 
 		// 使用原子计数器
 		var jobsDoneCounter int64
-		pool := NewWorkerPool(context.Background(), workerCount, rateLimit)
+		clock := NewManualClock(time.Unix(0, 0))
+		pool := NewWorkerPool(context.Background(), workerCount, rateLimit, WithClock(clock))
 
 		// 2. 执行
-		startTime := time.Now()
-
 		for i := 0; i < numJobs; i++ {
 			pool.Submit(func() {
 				atomic.AddInt64(&jobsDoneCounter, 1)
 			})
 		}
 
-		pool.Shutdown()
-		elapsedTime := time.Since(startTime)
-
 		// 3. 断言
-		if atomic.LoadInt64(&jobsDoneCounter) != numJobs {
-			t.Errorf("expected %d jobs to be done, but got %d", numJobs, atomic.LoadInt64(&jobsDoneCounter))
+		// 满桶的 10 个令牌立即放行，随后 dispatcher 在定时器上等待下一个令牌
+		done := func(n int64) func() bool {
+			return func() bool { return atomic.LoadInt64(&jobsDoneCounter) == n && clock.Timers() == 1 }
 		}
+		eventually(t, done(rateLimit))
 
-		// 核心断言：验证执行时间
-		// 20个任务，速率是10个/秒。理论上至少需要 (20-1)/10 = 1.9 秒。
-		// 我们设置一个略小于理论值的下限，来容忍调度误差。
-		// 如果执行时间远小于这个值，说明速率限制没有生效。
-		minExpectedDuration := ((numJobs - 1) / rateLimit) * time.Second
-		if elapsedTime < minExpectedDuration {
-			t.Errorf("rate limiting failed: expected to take at least %v, but took %v", minExpectedDuration, elapsedTime)
+		// 之后每推进 100ms 恰好多放行一个任务
+		for i := int64(rateLimit + 1); i < numJobs; i++ {
+			clock.Advance(100 * time.Millisecond)
+			eventually(t, done(i))
 		}
+		clock.Advance(100 * time.Millisecond)
+		pool.Shutdown()
 
-		t.Logf("Processed %d jobs in %v, respecting rate limit of %d/s.", numJobs, elapsedTime, rateLimit)
+		if got := atomic.LoadInt64(&jobsDoneCounter); got != numJobs {
+			t.Errorf("expected %d jobs to be done, but got %d", numJobs, got)
+		}
+
+		// 核心断言：50 个任务，速率 10 个/秒，突发 10 个，恰好需要 (50-10)/10 = 4 秒
+		if elapsed := clock.Now().Sub(time.Unix(0, 0)); elapsed != 4*time.Second {
+			t.Errorf("expected exactly 4s of virtual time, took %v", elapsed)
+		}
 	})
 }
 
@@ -95,7 +112,8 @@ func TestWorkerPool_ContextCancellation(t *testing.T) {
 
 		// 创建一个可以手动取消的 context
 		ctx, cancel := context.WithCancel(context.Background())
-		pool := NewWorkerPool(ctx, workerCount, rateLimit)
+		clock := NewManualClock(time.Unix(0, 0))
+		pool := NewWorkerPool(ctx, workerCount, rateLimit, WithClock(clock))
 
 		// 2. 执行
 		// 提交大量任务，远超 worker 数量和速率限制
@@ -103,18 +121,16 @@ func TestWorkerPool_ContextCancellation(t *testing.T) {
 		for i := 0; i < 100; i++ {
 			pool.Submit(func() {
 				atomic.AddInt64(&jobsStartedCounter, 1)
-				time.Sleep(50 * time.Millisecond) // 模拟任务耗时
+				time.Sleep(5 * time.Millisecond) // 模拟任务耗时
 				atomic.AddInt64(&jobsDoneCounter, 1)
 			})
 		}
 
-		// 等待一小段时间，确保有一些任务已经被 dispatcher 推送到 rateChan，
-		// 并且 worker 已经开始执行其中一部分。
-		time.Sleep(150 * time.Millisecond)
-
-		// 此刻，由于速率限制 (10/s) 和 worker 数量 (5)，
-		// 应该只有少数任务 (大概 1-2 个) 已经开始执行。
-		// 队列 taskChan 和 rateChan 中应该还有很多任务。
+		// 满桶放行 10 个任务，再让时间流逝 150ms，多补充 1.5 个令牌，即再放行 1 个。
+		// 等这 11 个任务都开始执行、dispatcher 在等待下一个令牌时再取消。
+		eventually(t, func() bool { return clock.Timers() == 1 })
+		clock.Advance(150 * time.Millisecond)
+		eventually(t, func() bool { return atomic.LoadInt64(&jobsStartedCounter) == 11 && clock.Timers() == 1 })
 
 		// 取消 context
 		cancel()
@@ -132,13 +148,96 @@ func TestWorkerPool_ContextCancellation(t *testing.T) {
 			t.Errorf("started jobs (%d) should equal done jobs (%d)", startedCount, doneCount)
 		}
 
-		// 关键断言：断言开始执行的任务数非常少，远小于提交的总数。
-		// 这个数字的上限取决于上面的 Sleep 时间和速率。
-		// 150ms / (1000ms/10) = 1.5 个令牌，所以最多启动 2 个。我们放宽到 5 个。
-		if startedCount > 5 {
-			t.Errorf("expected a small number of jobs to have started before cancellation, but got %d", startedCount)
+		// 关键断言：取消之后不会再有新任务开始，只有取消前放行的 11 个任务执行过
+		if startedCount != 11 {
+			t.Errorf("expected exactly 11 jobs to have started before cancellation, but got %d", startedCount)
 		}
 
 		t.Logf("Jobs submitted: 100. Jobs started/done after cancellation: %d", startedCount)
 	})
 }
+
+// TestWorkerPool_ConcurrencyLimit tests that a shared Semaphore caps the weighted in-flight work.
+func TestWorkerPool_ConcurrencyLimit(t *testing.T) {
+	t.Run("should never exceed the semaphore size", func(t *testing.T) {
+		// 1. 设置：10 个 worker，不限速，但在途任务的总权重不超过 4
+		const size = 4
+		sem := NewSemaphore(size)
+		pool := NewWorkerPoolWithLimits(context.Background(), 10, nil, sem)
+
+		var inFlight, peak, done int64
+		job := func(weight int64) Job {
+			return func() {
+				cur := atomic.AddInt64(&inFlight, weight)
+				for p := atomic.LoadInt64(&peak); cur > p && !atomic.CompareAndSwapInt64(&peak, p, cur); p = atomic.LoadInt64(&peak) {
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt64(&inFlight, -weight)
+				atomic.AddInt64(&done, 1)
+			}
+		}
+
+		// 2. 执行：混合提交权重 1 和权重 3 的任务
+		for i := 0; i < 40; i++ {
+			pool.SubmitWeighted(job(int64(1+2*(i%2))), int64(1+2*(i%2)))
+		}
+		pool.Shutdown()
+
+		// 3. 断言
+		if done != 40 {
+			t.Errorf("expected 40 jobs done, got %d", done)
+		}
+		if peak > size {
+			t.Errorf("peak in-flight weight %d exceeds semaphore size %d", peak, size)
+		}
+		if got := sem.InUse(); got != 0 {
+			t.Errorf("expected all permits released after Shutdown, %d still in use", got)
+		}
+	})
+
+	// 回归测试：权重超过容量的任务曾一直排在信号量队首，后面的任务和 Shutdown 都被卡住
+	t.Run("should reject a job heavier than the semaphore", func(t *testing.T) {
+		sem := NewSemaphore(2)
+		pool := NewWorkerPoolWithLimits(context.Background(), 1, nil, sem)
+
+		var done int64
+		if err := pool.SubmitWeighted(func() { atomic.AddInt64(&done, 1) }, 3); !errors.Is(err, ErrExceedsSize) {
+			t.Errorf("expected ErrExceedsSize, got %v", err)
+		}
+		pool.Submit(func() { atomic.AddInt64(&done, 1) })
+
+		finished := make(chan struct{})
+		go func() {
+			pool.Shutdown()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(time.Second):
+			t.Fatal("Shutdown hung behind an oversized job")
+		}
+		if done != 1 {
+			t.Errorf("expected only the light job to run, %d ran", done)
+		}
+	})
+
+	t.Run("should release permits of queued jobs on cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		sem := NewSemaphore(2)
+		pool := NewWorkerPoolWithLimits(ctx, 1, nil, sem)
+
+		block := make(chan struct{})
+		pool.Submit(func() { <-block })
+		pool.Submit(func() {})
+		pool.Submit(func() {})
+		// 第一个任务在执行，第二个拿到许可后等着 worker，dispatcher 在为第三个等待许可
+		eventually(t, func() bool { return semQueued(sem) == 1 })
+
+		cancel()
+		close(block)
+		pool.Shutdown()
+		if got := sem.InUse(); got != 0 {
+			t.Errorf("expected all permits released after cancellation, %d still in use", got)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package exercise05
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrExceedsSize 表示请求的许可数超过了信号量的容量，永远无法满足
+var ErrExceedsSize = errors.New("semaphore: request exceeds size")
+
+// Semaphore 是带权重的信号量，限制同时在途的工作量（例如内存或连接数），而 TokenBucket 限制的是速率。
+// 等待者严格按到达顺序获得许可：队首请求的权重很大时，后面的小请求也要排队，避免大请求被饿死。
+type Semaphore struct {
+	mu      sync.Mutex
+	size    int64
+	cur     int64     // 已发放的许可
+	waiters list.List // 等待中的 *semWaiter，按到达顺序排列
+}
+
+type semWaiter struct {
+	n       int64
+	ready   chan struct{} // 许可分配给该等待者或确定永远无法满足后关闭
+	granted bool
+	err     error // 永远无法满足时为 ErrExceedsSize
+}
+
+// NewSemaphore 创建容量为 size 的信号量
+func NewSemaphore(size int64) *Semaphore {
+	return &Semaphore{size: size}
+}
+
+// Size 返回当前容量
+func (s *Semaphore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// InUse 返回已发放、尚未释放的许可数
+func (s *Semaphore) InUse() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cur
+}
+
+// Acquire 获取 n 个许可，阻塞到许可足够或 ctx 被取消。
+// n 大于容量时立即返回 ErrExceedsSize，排队期间被 Resize 缩容到 n 以下时同样返回 ErrExceedsSize，
+// 免得它堵在队首让后面的等待者永远拿不到许可；ctx 被取消时返回 ctx.Err()，不占用任何许可。
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	s.mu.Lock()
+	if n <= 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.waiters.Len() == 0 && s.size-s.cur >= n {
+		s.cur += n
+		s.mu.Unlock()
+		return nil
+	}
+	if n > s.size {
+		s.mu.Unlock()
+		return ErrExceedsSize
+	}
+
+	w := &semWaiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.err
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if w.granted && w.err == nil {
+			// 取消与分配同时发生，把已分配的许可还回去
+			s.cur -= w.n
+		} else if !w.granted {
+			s.waiters.Remove(elem)
+		}
+		// 队首离开或许可被归还后，后面的等待者可能已经可以被满足
+		s.dispatch()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire 在不等待的情况下获取 n 个许可，成功返回 true。有人排队或 n 大于容量时返回 false。
+func (s *Semaphore) TryAcquire(n int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n <= 0 {
+		return true
+	}
+	if s.waiters.Len() == 0 && s.size-s.cur >= n {
+		s.cur += n
+		return true
+	}
+	return false
+}
+
+// Release 归还 n 个许可，归还的数量超过已发放的数量时 panic
+func (s *Semaphore) Release(n int64) {
+	if n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n > s.cur {
+		panic("exercise05: semaphore released more than held")
+	}
+	s.cur -= n
+	s.dispatch()
+}
+
+// Resize 修改容量。缩容时已发放的许可不受影响，只是在归还到新容量以下之前不会再发放新的许可；
+// 排队中权重超过新容量的 Acquire 轮到时返回 ErrExceedsSize。
+func (s *Semaphore) Resize(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = size
+	s.dispatch()
+}
+
+// dispatch 按到达顺序把空闲的许可分配给等待者，调用方需持有锁
+func (s *Semaphore) dispatch() {
+	for s.waiters.Len() > 0 {
+		front := s.waiters.Front()
+		w := front.Value.(*semWaiter)
+		if w.n > s.size {
+			// 缩容后再也等不到许可，让它出队，免得堵住后面的等待者
+			w.err = ErrExceedsSize
+		} else if s.size-s.cur < w.n {
+			break
+		} else {
+			s.cur += w.n
+		}
+		w.granted = true
+		close(w.ready)
+		s.waiters.Remove(front)
+	}
+}
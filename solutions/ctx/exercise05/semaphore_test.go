@@ -0,0 +1,219 @@
+package exercise05
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// semQueued 返回排队中的等待者数量
+func semQueued(s *Semaphore) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.waiters.Len()
+}
+
+// waitSemQueued 等待直到恰好有 n 个等待者排队
+func waitSemQueued(t *testing.T, s *Semaphore, n int) {
+	t.Helper()
+	eventually(t, func() bool { return semQueued(s) == n })
+}
+
+// acquireAsync 在新的 goroutine 中获取 n 个许可，返回的 channel 传回 Acquire 的结果
+func acquireAsync(ctx context.Context, s *Semaphore, n int64) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- s.Acquire(ctx, n) }()
+	return done
+}
+
+// assertPending 断言 done 还没有结果
+func assertPending(t *testing.T, done <-chan error, what string) {
+	t.Helper()
+	select {
+	case err := <-done:
+		t.Fatalf("%s should still be waiting, got %v", what, err)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+// TestSemaphore_TryAcquire tests the non-blocking path and Release accounting.
+func TestSemaphore_TryAcquire(t *testing.T) {
+	s := NewSemaphore(10)
+	if !s.TryAcquire(7) || !s.TryAcquire(3) {
+		t.Fatal("expected to acquire the full capacity")
+	}
+	if s.TryAcquire(1) {
+		t.Fatal("TryAcquire should fail when the semaphore is full")
+	}
+	s.Release(4)
+	if got := s.InUse(); got != 6 {
+		t.Fatalf("InUse = %d, want 6", got)
+	}
+	if !s.TryAcquire(4) {
+		t.Fatal("expected released permits to be reusable")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("releasing more than held should panic")
+		}
+	}()
+	s.Release(11)
+}
+
+// TestSemaphore_FIFO tests that a large request at the head is not starved by smaller ones behind it.
+func TestSemaphore_FIFO(t *testing.T) {
+	s := NewSemaphore(10)
+	s.TryAcquire(8)
+
+	large := acquireAsync(context.Background(), s, 10)
+	waitSemQueued(t, s, 1)
+
+	// 还剩 2 个许可，但队首在等 10 个，后来的小请求不能插队
+	if s.TryAcquire(1) {
+		t.Fatal("TryAcquire should not barge ahead of a queued waiter")
+	}
+	small := acquireAsync(context.Background(), s, 1)
+	waitSemQueued(t, s, 2)
+	assertPending(t, small, "small request")
+
+	s.Release(8)
+	if err := <-large; err != nil {
+		t.Fatalf("large request: %v", err)
+	}
+	assertPending(t, small, "small request")
+
+	s.Release(10)
+	if err := <-small; err != nil {
+		t.Fatalf("small request: %v", err)
+	}
+	if got := s.InUse(); got != 1 {
+		t.Fatalf("InUse = %d, want 1", got)
+	}
+}
+
+// TestSemaphore_Cancel tests that a canceled head waiter leaves the queue and unblocks the next one.
+func TestSemaphore_Cancel(t *testing.T) {
+	s := NewSemaphore(10)
+	s.TryAcquire(5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	head := acquireAsync(ctx, s, 10)
+	waitSemQueued(t, s, 1)
+	next := acquireAsync(context.Background(), s, 5)
+	waitSemQueued(t, s, 2)
+
+	cancel()
+	if err := <-head; !errors.Is(err, context.Canceled) {
+		t.Fatalf("head: expected context.Canceled, got %v", err)
+	}
+	if err := <-next; err != nil {
+		t.Fatalf("next: %v", err)
+	}
+	if got := s.InUse(); got != 10 {
+		t.Fatalf("InUse = %d, want 10: a canceled waiter must not hold permits", got)
+	}
+}
+
+// TestSemaphore_Resize tests growing and shrinking the capacity at runtime.
+func TestSemaphore_Resize(t *testing.T) {
+	s := NewSemaphore(4)
+
+	// 权重超过容量的请求立即失败，扩容之后才能满足
+	if err := s.Acquire(context.Background(), 6); !errors.Is(err, ErrExceedsSize) {
+		t.Fatalf("expected ErrExceedsSize above the size, got %v", err)
+	}
+	s.Resize(6)
+	if err := s.Acquire(context.Background(), 6); err != nil {
+		t.Fatalf("big request: %v", err)
+	}
+
+	// 缩容后已发放的许可不受影响，归还到新容量以下之前不再发放
+	s.Resize(2)
+	s.Release(4)
+	if s.TryAcquire(1) {
+		t.Fatal("2 of 2 permits still in use after shrinking, TryAcquire should fail")
+	}
+	s.Release(1)
+	if !s.TryAcquire(1) {
+		t.Fatal("expected a permit once usage fell below the new size")
+	}
+	if got := s.Size(); got != 2 {
+		t.Fatalf("Size = %d, want 2", got)
+	}
+}
+
+// TestSemaphore_ExceedsSize tests that a request that can never be satisfied does not block the queue.
+func TestSemaphore_ExceedsSize(t *testing.T) {
+	t.Run("should fail fast and leave the semaphore usable", func(t *testing.T) {
+		s := NewSemaphore(10)
+		if err := s.Acquire(context.Background(), 11); !errors.Is(err, ErrExceedsSize) {
+			t.Fatalf("expected ErrExceedsSize, got %v", err)
+		}
+		if s.TryAcquire(11) {
+			t.Fatal("TryAcquire above the size should fail")
+		}
+		if !s.TryAcquire(1) {
+			t.Fatal("expected an idle semaphore to grant 1 permit")
+		}
+	})
+
+	t.Run("should fail a queued request when shrunk below it", func(t *testing.T) {
+		s := NewSemaphore(10)
+		s.TryAcquire(8)
+		big := acquireAsync(context.Background(), s, 6)
+		waitSemQueued(t, s, 1)
+		small := acquireAsync(context.Background(), s, 1)
+		waitSemQueued(t, s, 2)
+
+		s.Resize(5)
+		if err := <-big; !errors.Is(err, ErrExceedsSize) {
+			t.Fatalf("expected the queued big request to fail with ErrExceedsSize, got %v", err)
+		}
+		assertPending(t, small, "small request")
+		s.Release(4)
+		if err := <-small; err != nil {
+			t.Fatalf("small request: %v", err)
+		}
+		if got := s.InUse(); got != 5 {
+			t.Errorf("InUse = %d, want 5", got)
+		}
+	})
+}
+
+// TestSemaphore_Concurrent tests that concurrent weighted holders never exceed the capacity.
+func TestSemaphore_Concurrent(t *testing.T) {
+	const size = 10
+	s := NewSemaphore(size)
+	var (
+		inUse atomic.Int64
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for j := 0; j < 20; j++ {
+				n := 1 + rng.Int63n(size)
+				if err := s.Acquire(context.Background(), n); err != nil {
+					t.Error(err)
+					return
+				}
+				if cur := inUse.Add(n); cur > size {
+					t.Errorf("in use %d exceeds size %d", cur, size)
+				}
+				inUse.Add(-n)
+				s.Release(n)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	if got := s.InUse(); got != 0 {
+		t.Fatalf("InUse = %d after all releases, want 0", got)
+	}
+}
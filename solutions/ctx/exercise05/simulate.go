@@ -0,0 +1,344 @@
+package exercise05
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// TraceEvent 是请求轨迹中的一条请求
+type TraceEvent struct {
+	Time time.Time
+	Key  string
+}
+
+// ReadTrace 读取请求轨迹。format 为 "csv"（每行 timestamp,key，可以有表头）或 "jsonl"
+// （每行 {"ts": ..., "key": ...}）。时间戳可以是 RFC 3339 字符串，也可以是 Unix 秒数（允许小数）。
+// 返回的事件按时间排序。
+func ReadTrace(r io.Reader, format string) ([]TraceEvent, error) {
+	var (
+		events []TraceEvent
+		err    error
+	)
+	switch format {
+	case "csv":
+		events, err = readCSVTrace(r)
+	case "jsonl":
+		events, err = readJSONLTrace(r)
+	default:
+		return nil, fmt.Errorf("unknown trace format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events, nil
+}
+
+func readCSVTrace(r io.Reader) ([]TraceEvent, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	var events []TraceEvent
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected timestamp,key", line)
+		}
+		ts, err := parseTraceTime(strings.TrimSpace(record[0]))
+		if err != nil {
+			if line == 1 {
+				continue // 表头
+			}
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		events = append(events, TraceEvent{Time: ts, Key: strings.TrimSpace(record[1])})
+	}
+}
+
+func readJSONLTrace(r io.Reader) ([]TraceEvent, error) {
+	scanner := bufio.NewScanner(r)
+	var events []TraceEvent
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var rec struct {
+			TS  json.RawMessage `json:"ts"`
+			Key string          `json:"key"`
+		}
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		raw := strings.Trim(string(rec.TS), `"`)
+		ts, err := parseTraceTime(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		events = append(events, TraceEvent{Time: ts, Key: rec.Key})
+	}
+	return events, scanner.Err()
+}
+
+// parseTraceTime 解析 RFC 3339 时间或 Unix 秒数
+func parseTraceTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		whole, frac := math.Modf(secs)
+		return time.Unix(int64(whole), int64(math.Round(frac*1e9))), nil
+	}
+	ts, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return ts, nil
+}
+
+// SimAlgorithms 是模拟器支持的限流算法
+var SimAlgorithms = []string{"tokenbucket", "gcra", "leaky", "slidinglog", "slidingcounter", "fixedwindow"}
+
+// SimConfig 描述一次回放的限流配置
+type SimConfig struct {
+	Algorithm  string
+	Rate       Limit         // 每秒放行的请求数
+	Burst      int           // 令牌桶、GCRA 的突发量，漏桶的排队容量
+	Window     time.Duration // 窗口类算法的窗口长度，窗口内的限额为 Rate*Window
+	PerKey     bool          // 每个 key 使用独立的限流器，否则所有 key 共享一个
+	MaxWait    time.Duration // 被拒绝的请求最多排队等待多久，0 表示立即拒绝
+	Resolution time.Duration // 排队请求重试的虚拟时间步长
+}
+
+// newSimLimiter 按配置创建一个使用 clock 的限流器
+func newSimLimiter(cfg SimConfig, clock Clock) (Limiter, error) {
+	windowLimit := int(math.Round(float64(cfg.Rate) * cfg.Window.Seconds()))
+	switch cfg.Algorithm {
+	case "tokenbucket":
+		return NewTokenBucketWithBurst(cfg.Rate, cfg.Burst, WithClock(clock)), nil
+	case "gcra":
+		return NewGCRA(cfg.Rate, cfg.Burst, WithClock(clock)), nil
+	case "leaky":
+		return NewLeakyBucket(cfg.Rate, cfg.Burst, WithClock(clock)), nil
+	case "slidinglog":
+		return NewSlidingWindowLog(windowLimit, cfg.Window, WithClock(clock)), nil
+	case "slidingcounter":
+		return NewSlidingWindowCounter(windowLimit, cfg.Window, WithClock(clock)), nil
+	case "fixedwindow":
+		return NewFixedWindow(windowLimit, cfg.Window, WithClock(clock)), nil
+	}
+	return nil, fmt.Errorf("unknown algorithm %q", cfg.Algorithm)
+}
+
+// KeyStats 是单个 key 的回放结果
+type KeyStats struct {
+	Key      string
+	Requests int
+	Allowed  int
+}
+
+// SimResult 是一次回放的结果
+type SimResult struct {
+	Config   SimConfig
+	Requests int
+	Allowed  int
+	Rejected int
+	Waits    []time.Duration // 每个被放行请求的等待时间，升序
+	PerKey   map[string]*KeyStats
+}
+
+// Percentile 返回被放行请求等待时间的 p 分位数（0 < p <= 100）
+func (r *SimResult) Percentile(p float64) time.Duration {
+	if len(r.Waits) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(r.Waits)))) - 1
+	return r.Waits[min(max(i, 0), len(r.Waits)-1)]
+}
+
+// Fairness 返回各个 key 放行比例的 Jain 公平性指数，1 表示每个 key 被放行的比例完全相同，
+// 越接近 1/key 数表示越集中在少数 key 上。
+func (r *SimResult) Fairness() float64 {
+	var sum, sumSq float64
+	for _, ks := range r.PerKey {
+		x := float64(ks.Allowed) / float64(ks.Requests)
+		sum += x
+		sumSq += x * x
+	}
+	if sumSq == 0 {
+		return 1
+	}
+	return sum * sum / (float64(len(r.PerKey)) * sumSq)
+}
+
+// WriteReport 把结果以文本表格的形式写入 w，topKeys 控制列出多少个请求最多的 key
+func (r *SimResult) WriteReport(w io.Writer, topKeys int) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "algorithm\t%s\n", r.Config.Algorithm)
+	fmt.Fprintf(tw, "requests\t%d\n", r.Requests)
+	fmt.Fprintf(tw, "allowed\t%d\t(%.1f%%)\n", r.Allowed, percent(r.Allowed, r.Requests))
+	fmt.Fprintf(tw, "rejected\t%d\t(%.1f%%)\n", r.Rejected, percent(r.Rejected, r.Requests))
+	fmt.Fprintf(tw, "wait p50/p90/p99/max\t%v / %v / %v / %v\n",
+		r.Percentile(50), r.Percentile(90), r.Percentile(99), r.Percentile(100))
+	fmt.Fprintf(tw, "keys\t%d\n", len(r.PerKey))
+	fmt.Fprintf(tw, "fairness (Jain)\t%.3f\n", r.Fairness())
+
+	keys := make([]*KeyStats, 0, len(r.PerKey))
+	for _, ks := range r.PerKey {
+		keys = append(keys, ks)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Requests != keys[j].Requests {
+			return keys[i].Requests > keys[j].Requests
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > topKeys {
+		keys = keys[:topKeys]
+	}
+	if len(keys) > 0 {
+		fmt.Fprintf(tw, "\nkey\trequests\tallowed\tratio\n")
+		for _, ks := range keys {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\n", ks.Key, ks.Requests, ks.Allowed, percent(ks.Allowed, ks.Requests))
+		}
+	}
+	return tw.Flush()
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// simQueue 是一个限流器上排队等待的请求
+type simQueue struct {
+	limiter Limiter
+	pending []TraceEvent
+}
+
+// Simulate 在虚拟时钟上按时间顺序回放 events。每个请求先调用 Allow；被拒绝且 MaxWait > 0 时进入该限流器的
+// FIFO 队列，时钟每前进 Resolution 就让队首重试一次，直到放行或等待超过 MaxWait 被拒绝。
+func Simulate(events []TraceEvent, cfg SimConfig) (*SimResult, error) {
+	if cfg.Resolution <= 0 {
+		cfg.Resolution = time.Millisecond
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Second
+	}
+	if len(events) == 0 {
+		return &SimResult{Config: cfg, PerKey: map[string]*KeyStats{}}, nil
+	}
+
+	clock := NewManualClock(events[0].Time)
+	shared, err := newSimLimiter(cfg, clock)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SimResult{Config: cfg, PerKey: make(map[string]*KeyStats)}
+	queues := map[string]*simQueue{}
+	waiting := map[*simQueue]struct{}{} // 有请求在排队的队列
+
+	queueFor := func(key string) (*simQueue, error) {
+		if !cfg.PerKey {
+			key = ""
+		}
+		q, ok := queues[key]
+		if !ok {
+			l := shared
+			if cfg.PerKey {
+				if l, err = newSimLimiter(cfg, clock); err != nil {
+					return nil, err
+				}
+			}
+			q = &simQueue{limiter: l}
+			queues[key] = q
+		}
+		return q, nil
+	}
+	record := func(ev TraceEvent, allowed bool, wait time.Duration) {
+		ks := res.PerKey[ev.Key]
+		if ks == nil {
+			ks = &KeyStats{Key: ev.Key}
+			res.PerKey[ev.Key] = ks
+		}
+		ks.Requests++
+		res.Requests++
+		if allowed {
+			ks.Allowed++
+			res.Allowed++
+			res.Waits = append(res.Waits, wait)
+		} else {
+			res.Rejected++
+		}
+	}
+	// drain 在当前时刻处理所有排队的请求：超时的拒绝，队首能放行的依次放行
+	drain := func() {
+		now := clock.Now()
+		for q := range waiting {
+			for len(q.pending) > 0 {
+				head := q.pending[0]
+				if wait := now.Sub(head.Time); wait > cfg.MaxWait {
+					record(head, false, 0)
+				} else if q.limiter.Allow() {
+					record(head, true, wait)
+				} else {
+					break
+				}
+				q.pending = q.pending[1:]
+			}
+			if len(q.pending) == 0 {
+				delete(waiting, q)
+			}
+		}
+	}
+	// advance 把时钟推进到 t，途中按 Resolution 的步长处理排队的请求
+	advance := func(t time.Time) {
+		for len(waiting) > 0 && clock.Now().Before(t) {
+			next := clock.Now().Add(cfg.Resolution)
+			if next.After(t) {
+				next = t
+			}
+			clock.Set(next)
+			drain()
+		}
+		clock.Set(t)
+	}
+
+	for _, ev := range events {
+		advance(ev.Time)
+		q, err := queueFor(ev.Key)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(q.pending) == 0 && q.limiter.Allow():
+			record(ev, true, 0)
+		case cfg.MaxWait > 0:
+			q.pending = append(q.pending, ev)
+			waiting[q] = struct{}{}
+		default:
+			record(ev, false, 0)
+		}
+	}
+	// 回放结束后继续推进时钟，直到所有排队的请求都有结果
+	for len(waiting) > 0 {
+		advance(clock.Now().Add(cfg.Resolution))
+	}
+
+	sort.Slice(res.Waits, func(i, j int) bool { return res.Waits[i] < res.Waits[j] })
+	return res, nil
+}
@@ -0,0 +1,153 @@
+package exercise05
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadTrace(t *testing.T) {
+	csvTrace := "timestamp,key\n" +
+		"1700000001.5,b\n" +
+		"2023-11-14T22:13:20Z,a\n"
+	jsonTrace := `{"ts": 1700000001.5, "key": "b"}` + "\n\n" +
+		`{"ts": "2023-11-14T22:13:20Z", "key": "a"}` + "\n"
+
+	for format, trace := range map[string]string{"csv": csvTrace, "jsonl": jsonTrace} {
+		events, err := ReadTrace(strings.NewReader(trace), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if len(events) != 2 {
+			t.Fatalf("%s: got %d events, want 2", format, len(events))
+		}
+		// 按时间排序
+		if events[0].Key != "a" || !events[0].Time.Equal(time.Unix(1700000000, 0)) {
+			t.Errorf("%s: first event = %+v", format, events[0])
+		}
+		if events[1].Key != "b" || !events[1].Time.Equal(time.Unix(1700000001, 5e8)) {
+			t.Errorf("%s: second event = %+v", format, events[1])
+		}
+	}
+
+	if _, err := ReadTrace(strings.NewReader("1,a\nyesterday,b\n"), "csv"); err == nil {
+		t.Error("expected an error for an invalid timestamp")
+	}
+	if _, err := ReadTrace(strings.NewReader(""), "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+// burstTrace 在同一时刻为每个 key 生成 counts 个请求
+func burstTrace(at time.Time, counts map[string]int) []TraceEvent {
+	var events []TraceEvent
+	for key, n := range counts {
+		for i := 0; i < n; i++ {
+			events = append(events, TraceEvent{Time: at, Key: key})
+		}
+	}
+	return events
+}
+
+func TestSimulateRejects(t *testing.T) {
+	events := burstTrace(time.Unix(0, 0), map[string]int{"a": 20})
+	res, err := Simulate(events, SimConfig{Algorithm: "tokenbucket", Rate: 10, Burst: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Requests != 20 || res.Allowed != 5 || res.Rejected != 15 {
+		t.Fatalf("requests/allowed/rejected = %d/%d/%d, want 20/5/15", res.Requests, res.Allowed, res.Rejected)
+	}
+	if res.Percentile(100) != 0 {
+		t.Errorf("max wait = %v, want 0 without queueing", res.Percentile(100))
+	}
+}
+
+func TestSimulateWaits(t *testing.T) {
+	events := burstTrace(time.Unix(0, 0), map[string]int{"a": 5})
+	res, err := Simulate(events, SimConfig{Algorithm: "tokenbucket", Rate: 10, Burst: 1, MaxWait: 350 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每 100ms 放行一个，第 5 个需要等 400ms，超过了 MaxWait
+	if res.Allowed != 4 || res.Rejected != 1 {
+		t.Fatalf("allowed/rejected = %d/%d, want 4/1", res.Allowed, res.Rejected)
+	}
+	want := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}
+	for i, w := range want {
+		if res.Waits[i] != w {
+			t.Errorf("wait[%d] = %v, want %v", i, res.Waits[i], w)
+		}
+	}
+	if p := res.Percentile(50); p != 100*time.Millisecond {
+		t.Errorf("p50 = %v, want 100ms", p)
+	}
+}
+
+func TestSimulateFairness(t *testing.T) {
+	events := burstTrace(time.Unix(0, 0), map[string]int{"noisy": 90, "quiet": 10})
+	// quiet 的请求排在 noisy 之后时，共享的限流器会被 noisy 用完
+	sortNoisyFirst(events)
+
+	shared, err := Simulate(events, SimConfig{Algorithm: "gcra", Rate: 10, Burst: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := shared.PerKey["quiet"].Allowed; got != 0 {
+		t.Errorf("shared: quiet allowed %d, want 0", got)
+	}
+	if f := shared.Fairness(); f > 0.6 {
+		t.Errorf("shared fairness = %.3f, want about 0.5", f)
+	}
+
+	perKey, err := Simulate(events, SimConfig{Algorithm: "gcra", Rate: 10, Burst: 10, PerKey: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := perKey.PerKey["quiet"].Allowed; got != 10 {
+		t.Errorf("per key: quiet allowed %d, want 10", got)
+	}
+	if f := perKey.Fairness(); f <= shared.Fairness() {
+		t.Errorf("per key fairness %.3f should beat shared %.3f", f, shared.Fairness())
+	}
+}
+
+// sortNoisyFirst 把 noisy 的请求移到最前面
+func sortNoisyFirst(events []TraceEvent) {
+	i := 0
+	for j := range events {
+		if events[j].Key == "noisy" {
+			events[i], events[j] = events[j], events[i]
+			i++
+		}
+	}
+}
+
+func TestSimulateAllAlgorithms(t *testing.T) {
+	var events []TraceEvent
+	start := time.Unix(0, 0)
+	for i := 0; i < 300; i++ {
+		events = append(events, TraceEvent{Time: start.Add(time.Duration(i) * 10 * time.Millisecond), Key: "k"})
+	}
+	// 3 秒内到达 300 个请求，限额每秒 50 个：除突发和窗口边界外大约放行 150 个
+	for _, algo := range SimAlgorithms {
+		res, err := Simulate(events, SimConfig{Algorithm: algo, Rate: 50, Burst: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", algo, err)
+		}
+		if res.Allowed < 140 || res.Allowed > 170 {
+			t.Errorf("%s: allowed %d, want about 150", algo, res.Allowed)
+		}
+		var report strings.Builder
+		if err := res.WriteReport(&report, 5); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(report.String(), "fairness") {
+			t.Errorf("%s: report missing fairness:\n%s", algo, report.String())
+		}
+	}
+
+	if _, err := Simulate(events, SimConfig{Algorithm: "bogus", Rate: 1, Burst: 1}); err == nil {
+		t.Error("expected an error for an unknown algorithm")
+	}
+}
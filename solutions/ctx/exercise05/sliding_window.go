@@ -0,0 +1,149 @@
+package exercise05
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// SlidingWindowLog 实现了滑动窗口日志算法：记录窗口内每一次放行的时间戳，
+// 任意长度为 window 的时间段内放行的请求都不会超过 limit 个。结果精确，但内存随 limit 线性增长。
+type SlidingWindowLog struct {
+	limit  int
+	window time.Duration
+	log    []time.Time // 窗口内放行请求的时间戳，按时间升序
+	clock  Clock
+	mu     sync.Mutex
+}
+
+// NewSlidingWindowLog 创建一个任意 window 时间内最多放行 limit 个请求的滑动窗口日志限流器
+func NewSlidingWindowLog(limit int, window time.Duration, opts ...Option) *SlidingWindowLog {
+	return &SlidingWindowLog{
+		limit:  limit,
+		window: window,
+		log:    make([]time.Time, 0, max(limit, 0)),
+		clock:  applyOptions(opts).clock,
+	}
+}
+
+// try 尝试在 now 时刻放行一个请求，失败时返回最早的记录滑出窗口还需要的时间，调用方需持有锁
+func (sw *SlidingWindowLog) try(now time.Time) (bool, time.Duration) {
+	if sw.limit <= 0 {
+		return false, never
+	}
+
+	// 丢弃已经滑出窗口的记录
+	boundary := now.Add(-sw.window)
+	expired := 0
+	for expired < len(sw.log) && !sw.log[expired].After(boundary) {
+		expired++
+	}
+	if expired > 0 {
+		sw.log = append(sw.log[:0], sw.log[expired:]...)
+	}
+
+	if len(sw.log) < sw.limit {
+		sw.log = append(sw.log, now)
+		return true, 0
+	}
+	return false, sw.log[0].Add(sw.window).Sub(now)
+}
+
+// Allow 在窗口内放行的请求少于 limit 个时放行
+func (sw *SlidingWindowLog) Allow() bool {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	ok, _ := sw.try(sw.clock.Now())
+	return ok
+}
+
+// Wait 阻塞直到窗口内有空位
+func (sw *SlidingWindowLog) Wait(ctx context.Context) error {
+	return waitUntilAllowed(ctx, sw.clock, func(now time.Time) (bool, time.Duration) {
+		sw.mu.Lock()
+		defer sw.mu.Unlock()
+		return sw.try(now)
+	})
+}
+
+// SlidingWindowCounter 实现了滑动窗口计数器算法：只保存当前和上一个固定窗口的计数，
+// 用上一个窗口按重叠比例加权后的计数近似滑动窗口内的请求数。内存固定，结果是近似值。
+type SlidingWindowCounter struct {
+	limit  int
+	window time.Duration
+	start  time.Time // 当前固定窗口的起始时间
+	prev   int       // 上一个固定窗口的计数
+	curr   int       // 当前固定窗口的计数
+	clock  Clock
+	mu     sync.Mutex
+}
+
+// NewSlidingWindowCounter 创建一个近似“任意 window 时间内最多 limit 个请求”的滑动窗口计数器
+func NewSlidingWindowCounter(limit int, window time.Duration, opts ...Option) *SlidingWindowCounter {
+	return &SlidingWindowCounter{
+		limit:  limit,
+		window: window,
+		clock:  applyOptions(opts).clock,
+	}
+}
+
+// roll 把固定窗口推进到 now 所在的窗口，调用方需持有锁
+func (sc *SlidingWindowCounter) roll(now time.Time) {
+	start := now.Truncate(sc.window)
+	switch {
+	case start.Equal(sc.start):
+		return
+	case start.Equal(sc.start.Add(sc.window)):
+		sc.prev = sc.curr
+	default:
+		sc.prev = 0
+	}
+	sc.curr = 0
+	sc.start = start
+}
+
+// try 尝试在 now 时刻放行一个请求，失败时返回估算值降到 limit 以下还需要的时间，调用方需持有锁
+func (sc *SlidingWindowCounter) try(now time.Time) (bool, time.Duration) {
+	if sc.limit <= 0 {
+		return false, never
+	}
+	sc.roll(now)
+
+	// 上一个窗口仍与滑动窗口重叠的比例
+	elapsed := now.Sub(sc.start)
+	weight := 1 - float64(elapsed)/float64(sc.window)
+	if float64(sc.prev)*weight+float64(sc.curr)+1 <= float64(sc.limit) {
+		sc.curr++
+		return true, 0
+	}
+
+	// 当前窗口已满，只能等到下一个窗口
+	if sc.curr+1 > sc.limit {
+		return false, sc.start.Add(sc.window).Sub(now)
+	}
+	// 否则等待上一个窗口的权重下降到 prev*(1-x/window) <= limit-curr-1
+	x := float64(sc.window) * (1 - float64(sc.limit-sc.curr-1)/float64(sc.prev))
+	wait := sc.start.Add(time.Duration(math.Ceil(x))).Sub(now)
+	if wait <= 0 {
+		wait = time.Nanosecond
+	}
+	return false, wait
+}
+
+// Allow 在估算的窗口内请求数加上这次请求不超过 limit 时放行
+func (sc *SlidingWindowCounter) Allow() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	ok, _ := sc.try(sc.clock.Now())
+	return ok
+}
+
+// Wait 阻塞直到估算的窗口内请求数允许再放行一个请求
+func (sc *SlidingWindowCounter) Wait(ctx context.Context) error {
+	return waitUntilAllowed(ctx, sc.clock, func(now time.Time) (bool, time.Duration) {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		return sc.try(now)
+	})
+}
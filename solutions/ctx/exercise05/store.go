@@ -0,0 +1,266 @@
+package exercise05
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// StoreAlgorithm 指定共享存储中执行的限流算法
+type StoreAlgorithm int
+
+const (
+	// StoreGCRA 在存储中为每个 key 保存一个理论到达时间
+	StoreGCRA StoreAlgorithm = iota
+	// StoreTokenBucket 在存储中为每个 key 保存令牌数和上次补充时间
+	StoreTokenBucket
+)
+
+// StoreRequest 描述一次对共享限流状态的原子更新
+type StoreRequest struct {
+	Algorithm StoreAlgorithm
+	Key       string
+	Limit     Limit
+	Burst     int
+	N         int
+}
+
+// StoreResult 是一次原子更新的结果
+type StoreResult struct {
+	Allowed    bool
+	RetryAfter time.Duration // 被拒绝时距离可以放行还需要的时间，never 表示永远无法放行
+	Remaining  int           // 放行后剩余的额度
+}
+
+// Store 保存跨进程共享的限流状态。Take 必须原子地完成“读取状态-计算-写回”，
+// 时间以存储自己的时钟为准，避免各个进程之间的时钟偏差影响结果。
+type Store interface {
+	Take(ctx context.Context, req StoreRequest) (StoreResult, error)
+}
+
+// storeState 是单个 key 的限流状态，不同算法使用其中不同的字段
+type storeState struct {
+	tat    time.Time // GCRA 的理论到达时间
+	tokens float64   // 令牌桶当前的令牌数
+	last   time.Time // 令牌桶上次补充的时间
+	seen   bool
+	idleAt time.Time // 从这一刻起状态与新建的等价，可以被回收
+}
+
+// take 在 now 时刻按 req 指定的算法更新状态，调用方需保证原子性
+func (s *storeState) take(now time.Time, req StoreRequest) StoreResult {
+	if req.Limit == Inf || req.N <= 0 {
+		return StoreResult{Allowed: true, Remaining: req.Burst}
+	}
+	if req.N > req.Burst {
+		return StoreResult{RetryAfter: never}
+	}
+	if req.Algorithm == StoreTokenBucket {
+		return s.takeTokenBucket(now, req)
+	}
+	return s.takeGCRA(now, req)
+}
+
+func (s *storeState) takeGCRA(now time.Time, req StoreRequest) StoreResult {
+	if req.Limit <= 0 {
+		return StoreResult{RetryAfter: never}
+	}
+	interval := req.Limit.durationFromTokens(1)
+	tat := s.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT := tat.Add(time.Duration(req.N) * interval)
+	allowAt := newTAT.Add(-time.Duration(req.Burst) * interval)
+	if allowAt.After(now) {
+		return StoreResult{RetryAfter: allowAt.Sub(now)}
+	}
+	s.tat = newTAT
+	s.idleAt = newTAT
+	remaining := (time.Duration(req.Burst)*interval - newTAT.Sub(now)) / interval
+	return StoreResult{Allowed: true, Remaining: int(remaining)}
+}
+
+func (s *storeState) takeTokenBucket(now time.Time, req StoreRequest) StoreResult {
+	burst := float64(req.Burst)
+	if !s.seen {
+		s.seen = true
+		s.tokens = burst // 第一次见到的 key 桶是满的
+		s.last = now
+	}
+	if elapsed := now.Sub(s.last); elapsed > 0 {
+		s.tokens = math.Min(burst, s.tokens+req.Limit.tokensFromDuration(elapsed))
+	}
+	s.last = now
+
+	need := float64(req.N)
+	if s.tokens < need {
+		return StoreResult{RetryAfter: req.Limit.durationFromTokens(need - s.tokens), Remaining: int(s.tokens)}
+	}
+	s.tokens -= need
+	s.idleAt = now
+	if s.tokens < burst {
+		s.idleAt = now.Add(req.Limit.durationFromTokens(burst - s.tokens))
+	}
+	return StoreResult{Allowed: true, Remaining: int(s.tokens)}
+}
+
+// memoryStoreSweepInterval 是 MemoryStore 在 Take 中顺带回收空闲状态的最小间隔
+const memoryStoreSweepInterval = time.Minute
+
+// MemoryStore 是进程内的 Store 实现，可以被同一进程中的多个限流器共享，也可以由 StoreServer 对外提供服务。
+// 额度已经完全恢复的 key 与从未出现过的等价，Take 每隔 memoryStoreSweepInterval 顺带回收一次，
+// 也可以调用 EvictIdle 立即回收，内存占用只与最近活跃的 key 数量有关。
+type MemoryStore struct {
+	mu        sync.Mutex
+	states    map[string]*storeState
+	clock     Clock
+	lastSweep time.Time
+}
+
+// NewMemoryStore 创建一个空的内存存储
+func NewMemoryStore(opts ...Option) *MemoryStore {
+	clock := applyOptions(opts).clock
+	return &MemoryStore{
+		states:    make(map[string]*storeState),
+		clock:     clock,
+		lastSweep: clock.Now(),
+	}
+}
+
+// Take 在同一把锁下读取并更新 key 的状态
+func (s *MemoryStore) Take(ctx context.Context, req StoreRequest) (StoreResult, error) {
+	if err := ctx.Err(); err != nil {
+		return StoreResult{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	if now.Sub(s.lastSweep) >= memoryStoreSweepInterval {
+		s.evictLocked(now)
+	}
+	state, ok := s.states[req.Key]
+	if !ok {
+		state = &storeState{}
+		s.states[req.Key] = state
+	}
+	return state.take(now, req), nil
+}
+
+// Len 返回当前保存的 key 数量
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.states)
+}
+
+// EvictIdle 回收所有额度已经完全恢复的 key 并返回回收的数量
+func (s *MemoryStore) EvictIdle() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.evictLocked(s.clock.Now())
+}
+
+// evictLocked 删除在 now 时刻已经空闲的状态，调用方需持有锁
+func (s *MemoryStore) evictLocked(now time.Time) int {
+	s.lastSweep = now
+	evicted := 0
+	for key, state := range s.states {
+		if !state.idleAt.After(now) {
+			delete(s.states, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+const (
+	// storeTimeout 是 Allow 访问存储的超时时间
+	storeTimeout = 100 * time.Millisecond
+	// storeRetryInterval 是存储不可用后，改用本地限流多久再重新尝试存储
+	storeRetryInterval = time.Second
+)
+
+// StoreLimiter 是基于共享存储的限流器，多个进程使用同一个存储和 key 时共同遵守一个限额。
+// 存储不可用时退化为进程内的令牌桶，此时每个进程各自限流，总速率会暂时放大到进程数倍。
+type StoreLimiter struct {
+	store     Store
+	req       StoreRequest
+	fallback  *TokenBucket
+	clock     Clock
+	mu        sync.Mutex
+	downUntil time.Time // 在此之前认为存储不可用，直接使用本地限流
+}
+
+// NewStoreLimiter 创建一个在 store 中以 key 记录状态、速率为 limit、突发量为 burst 的共享限流器
+func NewStoreLimiter(store Store, key string, algorithm StoreAlgorithm, limit Limit, burst int, opts ...Option) *StoreLimiter {
+	return &StoreLimiter{
+		store:    store,
+		req:      StoreRequest{Algorithm: algorithm, Key: key, Limit: limit, Burst: burst, N: 1},
+		fallback: NewTokenBucketWithBurst(limit, burst, opts...),
+		clock:    applyOptions(opts).clock,
+	}
+}
+
+// take 向存储请求一个许可，每次请求最多等待 storeTimeout。存储出错或超时时返回 false 的 ok，
+// 调用方应改用本地限流；除非是调用方自己的 ctx 结束，否则在 storeRetryInterval 内不再访问存储。
+func (sl *StoreLimiter) take(ctx context.Context) (res StoreResult, ok bool) {
+	sl.mu.Lock()
+	down := sl.clock.Now().Before(sl.downUntil)
+	sl.mu.Unlock()
+	if down {
+		return StoreResult{}, false
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	res, err := sl.store.Take(callCtx, sl.req)
+	if err != nil {
+		if ctx.Err() == nil {
+			sl.mu.Lock()
+			sl.downUntil = sl.clock.Now().Add(storeRetryInterval)
+			sl.mu.Unlock()
+		}
+		return StoreResult{}, false
+	}
+	return res, true
+}
+
+// Allow 向共享存储请求一个许可，存储不可用时由本地令牌桶决定
+func (sl *StoreLimiter) Allow() bool {
+	res, ok := sl.take(context.Background())
+	if !ok {
+		return sl.fallback.Allow()
+	}
+	return res.Allowed
+}
+
+// Wait 反复向共享存储请求许可，被拒绝时按存储返回的时间等待后重试；存储不可用时改由本地令牌桶等待
+func (sl *StoreLimiter) Wait(ctx context.Context) error {
+	for {
+		res, ok := sl.take(ctx)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !ok {
+			return sl.fallback.Wait(ctx)
+		}
+		if res.Allowed {
+			return nil
+		}
+		if res.RetryAfter == never {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		timer := sl.clock.NewTimer(res.RetryAfter)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
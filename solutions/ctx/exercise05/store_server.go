@@ -0,0 +1,227 @@
+package exercise05
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+StoreServer 与 RemoteStore 之间使用基于文本行的简单协议，一个连接上可以依次发送多个请求：
+请求：TAKE <algorithm> <quoted-key> <limit> <burst> <n>\n
+响应：OK <allowed:0|1> <retry-after-nanos> <remaining>\n
+出错：ERR <message>\n
+key 使用 Go 的带引号字符串格式，因此可以包含空格等任意字符。
+*/
+
+// StoreServer 通过 TCP 对外提供一个 Store，让多个进程共享同一份限流状态
+type StoreServer struct {
+	store Store
+	mu    sync.Mutex
+	ln    net.Listener
+	conns map[net.Conn]struct{}
+	done  bool
+	wg    sync.WaitGroup
+}
+
+// NewStoreServer 创建一个对外提供 store 的服务端
+func NewStoreServer(store Store) *StoreServer {
+	return &StoreServer{store: store, conns: make(map[net.Conn]struct{})}
+}
+
+// Serve 在 ln 上接受连接并处理请求，直到 Close 被调用。Close 之后返回 nil。
+func (s *StoreServer) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return ln.Close()
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.done {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// Close 停止接受新连接，关闭所有已有连接并等待处理它们的 goroutine 退出
+func (s *StoreServer) Close() error {
+	s.mu.Lock()
+	s.done = true
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// handle 依次处理一个连接上的请求
+func (s *StoreServer) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		req, err := parseStoreRequest(line)
+		if err != nil {
+			fmt.Fprintf(w, "ERR %s\n", err)
+		} else if res, err := s.store.Take(context.Background(), req); err != nil {
+			fmt.Fprintf(w, "ERR %s\n", err)
+		} else {
+			fmt.Fprintf(w, "OK %d %d %d\n", boolToInt(res.Allowed), int64(res.RetryAfter), res.Remaining)
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// parseStoreRequest 解析一行 TAKE 请求
+func parseStoreRequest(line string) (StoreRequest, error) {
+	var (
+		req   StoreRequest
+		algo  int
+		limit float64
+	)
+	if _, err := fmt.Sscanf(line, "TAKE %d %q %g %d %d\n", &algo, &req.Key, &limit, &req.Burst, &req.N); err != nil {
+		return StoreRequest{}, fmt.Errorf("malformed request: %v", err)
+	}
+	if algo != int(StoreGCRA) && algo != int(StoreTokenBucket) {
+		return StoreRequest{}, fmt.Errorf("unknown algorithm %d", algo)
+	}
+	req.Algorithm = StoreAlgorithm(algo)
+	req.Limit = Limit(limit)
+	return req, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// remoteDialTimeout 是 RemoteStore 建立连接的默认超时时间
+const remoteDialTimeout = time.Second
+
+// RemoteStore 是连接到 StoreServer 的 Store 客户端。同一个 RemoteStore 上的请求串行发送，
+// 连接出错后会在下一次请求时重新建立。
+type RemoteStore struct {
+	addr string
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewRemoteStore 创建一个连接到 addr 的客户端，连接在第一次请求时建立
+func NewRemoteStore(addr string) *RemoteStore {
+	return &RemoteStore{addr: addr}
+}
+
+// Take 把请求发送给服务端并等待结果，ctx 的截止时间和取消同时作用于建立连接和读写
+func (s *RemoteStore) Take(ctx context.Context, req StoreRequest) (StoreResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		dialer := net.Dialer{Timeout: remoteDialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+		if err != nil {
+			return StoreResult{}, err
+		}
+		s.conn, s.r = conn, bufio.NewReader(conn)
+	}
+
+	res, err := s.roundTrip(ctx, req)
+	if err != nil {
+		// 连接状态未知，丢弃它，下次重新建立
+		s.conn.Close()
+		s.conn, s.r = nil, nil
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+	}
+	return res, err
+}
+
+// roundTrip 在已建立的连接上发送一次请求并读取响应，调用方需持有锁
+func (s *RemoteStore) roundTrip(ctx context.Context, req StoreRequest) (StoreResult, error) {
+	conn := s.conn
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Time{}
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return StoreResult{}, err
+	}
+	// ctx 被取消时把截止时间设为过去，让阻塞中的读写立即返回
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	if _, err := fmt.Fprintf(s.conn, "TAKE %d %s %s %d %d\n", req.Algorithm, strconv.Quote(req.Key),
+		strconv.FormatFloat(float64(req.Limit), 'g', -1, 64), req.Burst, req.N); err != nil {
+		return StoreResult{}, err
+	}
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return StoreResult{}, err
+	}
+
+	var (
+		allowed, remaining int
+		retryAfter         int64
+	)
+	if _, err := fmt.Sscanf(line, "OK %d %d %d\n", &allowed, &retryAfter, &remaining); err != nil {
+		return StoreResult{}, fmt.Errorf("store server: %s", line)
+	}
+	return StoreResult{Allowed: allowed == 1, RetryAfter: time.Duration(retryAfter), Remaining: remaining}, nil
+}
+
+// Close 关闭与服务端的连接
+func (s *RemoteStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.r = nil, nil
+	return err
+}
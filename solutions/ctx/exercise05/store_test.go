@@ -0,0 +1,335 @@
+package exercise05
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMemoryStore tests the atomic GCRA and token-bucket updates against a manual clock.
+func TestMemoryStore(t *testing.T) {
+	for _, algo := range []StoreAlgorithm{StoreGCRA, StoreTokenBucket} {
+		t.Run(map[StoreAlgorithm]string{StoreGCRA: "GCRA", StoreTokenBucket: "TokenBucket"}[algo], func(t *testing.T) {
+			clock := NewManualClock(time.Unix(0, 0))
+			store := NewMemoryStore(WithClock(clock))
+			req := StoreRequest{Algorithm: algo, Key: "user:1", Limit: 10, Burst: 5, N: 1}
+
+			for i := 0; i < 5; i++ {
+				res, err := store.Take(context.Background(), req)
+				if err != nil || !res.Allowed {
+					t.Fatalf("request %d: expected to be allowed, got %+v, %v", i, res, err)
+				}
+				if res.Remaining != 4-i {
+					t.Errorf("request %d: expected %d remaining, got %d", i, 4-i, res.Remaining)
+				}
+			}
+
+			res, _ := store.Take(context.Background(), req)
+			if res.Allowed || res.RetryAfter != 100*time.Millisecond {
+				t.Fatalf("expected a rejection with a 100ms retry, got %+v", res)
+			}
+
+			clock.Advance(100 * time.Millisecond)
+			if res, _ := store.Take(context.Background(), req); !res.Allowed {
+				t.Errorf("expected a permit after 100ms, got %+v", res)
+			}
+
+			other := req
+			other.Key = "user:2"
+			if res, _ := store.Take(context.Background(), other); !res.Allowed {
+				t.Errorf("expected keys to be limited independently, got %+v", res)
+			}
+
+			tooMany := req
+			tooMany.N = 6
+			if res, _ := store.Take(context.Background(), tooMany); res.Allowed || res.RetryAfter != never {
+				t.Errorf("expected n above burst to never be allowed, got %+v", res)
+			}
+		})
+	}
+}
+
+// TestMemoryStore_EvictIdle tests that keys whose quota has fully recovered are dropped.
+func TestMemoryStore_EvictIdle(t *testing.T) {
+	for _, algo := range []StoreAlgorithm{StoreGCRA, StoreTokenBucket} {
+		clock := NewManualClock(time.Unix(0, 0))
+		store := NewMemoryStore(WithClock(clock))
+		fast := StoreRequest{Algorithm: algo, Key: "fast", Limit: 10, Burst: 5, N: 1}
+		slow := StoreRequest{Algorithm: algo, Key: "slow", Limit: Every(time.Hour), Burst: 5, N: 1}
+		for _, req := range []StoreRequest{fast, fast, slow} {
+			store.Take(context.Background(), req)
+		}
+
+		// fast 用掉的两个令牌 200ms 后恢复，slow 的一个令牌要一小时
+		if got := store.EvictIdle(); got != 0 {
+			t.Errorf("algorithm %d: expected no idle keys yet, evicted %d", algo, got)
+		}
+		clock.Advance(200 * time.Millisecond)
+		if got := store.EvictIdle(); got != 1 || store.Len() != 1 {
+			t.Errorf("algorithm %d: expected only fast to be evicted, evicted %d, %d keys left", algo, got, store.Len())
+		}
+
+		// Take 定期顺带回收，不需要调用方调用 EvictIdle
+		for i := 0; i < 100; i++ {
+			store.Take(context.Background(), StoreRequest{Algorithm: algo, Key: fmt.Sprint("k", i), Limit: 10, Burst: 5, N: 1})
+		}
+		clock.Advance(time.Hour)
+		store.Take(context.Background(), fast)
+		if got := store.Len(); got != 1 {
+			t.Errorf("algorithm %d: expected idle keys to be swept by Take, %d keys left", algo, got)
+		}
+	}
+}
+
+// startStoreServer 在随机端口上启动一个 StoreServer，测试结束时关闭
+func startStoreServer(t *testing.T, store Store) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewStoreServer(store)
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return ln.Addr().String()
+}
+
+// TestStoreLimiter_SharedAcrossReplicas tests that replicas talking to one store share a single limit.
+func TestStoreLimiter_SharedAcrossReplicas(t *testing.T) {
+	const replicas = 4
+
+	clock := NewManualClock(time.Unix(0, 0))
+	addr := startStoreServer(t, NewMemoryStore(WithClock(clock)))
+
+	// 每个“副本”使用独立的 TCP 连接
+	var limiters []*StoreLimiter
+	for i := 0; i < replicas; i++ {
+		remote := NewRemoteStore(addr)
+		t.Cleanup(func() { remote.Close() })
+		limiters = append(limiters, NewStoreLimiter(remote, "api \"orders\"", StoreGCRA, 10, 10))
+	}
+
+	count := func() int64 {
+		var allowed int64
+		var wg sync.WaitGroup
+		for _, l := range limiters {
+			wg.Add(1)
+			go func(l *StoreLimiter) {
+				defer wg.Done()
+				for i := 0; i < 10; i++ {
+					if l.Allow() {
+						atomic.AddInt64(&allowed, 1)
+					}
+				}
+			}(l)
+		}
+		wg.Wait()
+		return allowed
+	}
+
+	if got := count(); got != 10 {
+		t.Errorf("expected %d replicas to share a burst of 10, got %d allowed", replicas, got)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if got := count(); got != 5 {
+		t.Errorf("expected 5 permits after 500ms at 10/s, got %d", got)
+	}
+}
+
+// TestStoreLimiter_Wait tests that Wait retries after the delay reported by the store.
+func TestStoreLimiter_Wait(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	store := NewMemoryStore(WithClock(clock))
+	l := NewStoreLimiter(store, "k", StoreTokenBucket, 10, 1, WithClock(clock))
+
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(context.Background()) }()
+	clock.BlockUntil(1)
+	clock.Advance(100 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// countingStore 统计请求次数，并总是返回错误，模拟不可用的存储
+type countingStore struct {
+	calls int64
+}
+
+func (s *countingStore) Take(context.Context, StoreRequest) (StoreResult, error) {
+	atomic.AddInt64(&s.calls, 1)
+	return StoreResult{}, errors.New("store unavailable")
+}
+
+// hangingStore 统计请求次数，并一直阻塞到 ctx 结束，模拟卡住的存储
+type hangingStore struct {
+	calls int64
+}
+
+func (s *hangingStore) Take(ctx context.Context, _ StoreRequest) (StoreResult, error) {
+	atomic.AddInt64(&s.calls, 1)
+	<-ctx.Done()
+	return StoreResult{}, ctx.Err()
+}
+
+// TestStoreLimiter_Fallback tests that an unreachable store falls back to local limiting.
+func TestStoreLimiter_Fallback(t *testing.T) {
+	t.Run("unreachable server", func(t *testing.T) {
+		// 先占用再释放一个端口，得到一个没有服务监听的地址
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := ln.Addr().String()
+		ln.Close()
+
+		l := NewStoreLimiter(NewRemoteStore(addr), "k", StoreGCRA, Every(time.Hour), 3)
+		allowed := 0
+		for i := 0; i < 10; i++ {
+			if l.Allow() {
+				allowed++
+			}
+		}
+		if allowed != 3 {
+			t.Errorf("expected the local fallback to allow its burst of 3, got %d", allowed)
+		}
+	})
+
+	t.Run("store is retried only after the retry interval", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		store := &countingStore{}
+		l := NewStoreLimiter(store, "k", StoreGCRA, 1000, 1000, WithClock(clock))
+
+		for i := 0; i < 100; i++ {
+			l.Allow()
+		}
+		if got := atomic.LoadInt64(&store.calls); got != 1 {
+			t.Errorf("expected a single store call before backing off, got %d", got)
+		}
+
+		clock.Advance(storeRetryInterval)
+		l.Allow()
+		if got := atomic.LoadInt64(&store.calls); got != 2 {
+			t.Errorf("expected the store to be retried after %v, got %d calls", storeRetryInterval, got)
+		}
+	})
+
+	t.Run("a hanging store is marked down after one timeout", func(t *testing.T) {
+		store := &hangingStore{}
+		l := NewStoreLimiter(store, "k", StoreGCRA, 1000, 1000)
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			l.Allow()
+		}
+		if got := atomic.LoadInt64(&store.calls); got != 1 {
+			t.Errorf("expected a single store call before backing off, got %d", got)
+		}
+		if elapsed := time.Since(start); elapsed > 3*storeTimeout {
+			t.Errorf("expected only the first call to wait for the store, took %v", elapsed)
+		}
+	})
+
+	t.Run("a cancelled caller does not mark the store down", func(t *testing.T) {
+		store := &hangingStore{}
+		l := NewStoreLimiter(store, "k", StoreGCRA, 1000, 1000)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := l.Wait(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		l.Allow()
+		if got := atomic.LoadInt64(&store.calls); got != 2 {
+			t.Errorf("expected the store to still be used after a cancelled Wait, got %d calls", got)
+		}
+	})
+
+	t.Run("wait falls back too", func(t *testing.T) {
+		l := NewStoreLimiter(&countingStore{}, "k", StoreGCRA, 1000, 1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := l.Wait(ctx); err != nil {
+			t.Errorf("expected the local fallback to grant a permit, got %v", err)
+		}
+	})
+}
+
+// TestStoreServer_Protocol tests malformed requests and reconnection.
+func TestStoreServer_Protocol(t *testing.T) {
+	addr := startStoreServer(t, NewMemoryStore())
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("HELLO\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, _ := conn.Read(buf)
+	if got := string(buf[:n]); len(got) < 4 || got[:4] != "ERR " {
+		t.Errorf("expected an ERR response, got %q", got)
+	}
+
+	remote := NewRemoteStore(addr)
+	defer remote.Close()
+	req := StoreRequest{Algorithm: StoreGCRA, Key: "k", Limit: 10, Burst: 2, N: 1}
+	if _, err := remote.Take(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	remote.conn.Close() // 模拟连接中断
+	if _, err := remote.Take(context.Background(), req); err == nil {
+		t.Fatal("expected an error on a broken connection")
+	}
+	// 中断时的请求没有到达服务端，重连后消耗的是第二个令牌
+	if res, err := remote.Take(context.Background(), req); err != nil || !res.Allowed || res.Remaining != 0 {
+		t.Errorf("expected the client to reconnect and take the last token, got %+v, %v", res, err)
+	}
+}
+
+// TestRemoteStore_Cancel tests that cancelling ctx interrupts a request waiting for the server.
+func TestRemoteStore_Cancel(t *testing.T) {
+	// 只接受连接、从不回复的服务端
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	remote := NewRemoteStore(ln.Addr().String())
+	defer remote.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := remote.Take(ctx, StoreRequest{Key: "k", Limit: 10, Burst: 1, N: 1})
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Take ignored the cancellation")
+	}
+}
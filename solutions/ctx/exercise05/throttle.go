@@ -0,0 +1,198 @@
+package exercise05
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+)
+
+// 以下包装器用 TokenBucket 限制字节流的带宽，一个令牌对应一个字节。
+// 同一个 TokenBucket 可以被多个包装器共享，例如让所有下载连接共同遵守 10MB/s 的上限。
+// 每次读写最多处理桶容量（突发量）个字节，因此桶容量同时决定了单次 I/O 的最大块大小。
+
+// chunkSize 返回一次读写最多可以处理的字节数，桶容量小于 1 字节时返回 ErrExceedsBurst
+func chunkSize(tb *TokenBucket, n int) (int, error) {
+	if tb.Limit() == Inf {
+		return n, nil
+	}
+	burst := tb.Burst()
+	if burst < 1 {
+		return 0, ErrExceedsBurst
+	}
+	return min(n, burst), nil
+}
+
+// Reader 是按 TokenBucket 限速的 io.Reader
+type Reader struct {
+	ctx context.Context
+	r   io.Reader
+	tb  *TokenBucket
+}
+
+// NewReader 返回一个读取速率不超过 tb 的 Reader，ctx 结束时正在等待的 Read 返回 ctx.Err()
+func NewReader(ctx context.Context, r io.Reader, tb *TokenBucket) *Reader {
+	return &Reader{ctx: ctx, r: r, tb: tb}
+}
+
+// Read 先读取最多一个桶容量的数据，再按实际读到的字节数扣除令牌，令牌不足时等待后再返回
+func (r *Reader) Read(p []byte) (int, error) {
+	size, err := chunkSize(r.tb, len(p))
+	if err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p[:size])
+	if n > 0 {
+		if werr := r.tb.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Writer 是按 TokenBucket 限速的 io.Writer
+type Writer struct {
+	ctx context.Context
+	w   io.Writer
+	tb  *TokenBucket
+}
+
+// NewWriter 返回一个写入速率不超过 tb 的 Writer，ctx 结束时正在等待的 Write 返回 ctx.Err()
+func NewWriter(ctx context.Context, w io.Writer, tb *TokenBucket) *Writer {
+	return &Writer{ctx: ctx, w: w, tb: tb}
+}
+
+// Write 把 p 切成不超过桶容量的块，每块先等待令牌再写入
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		size, err := chunkSize(w.tb, len(p)-written)
+		if err != nil {
+			return written, err
+		}
+		if err := w.tb.WaitN(w.ctx, size); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(p[written : written+size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Conn 是读写带宽分别受限的 net.Conn。Close 会让正在等待令牌的 Read/Write 立即返回。
+type Conn struct {
+	net.Conn
+	reader *Reader
+	writer *Writer
+	cancel context.CancelFunc
+	onDone func()
+	once   sync.Once
+}
+
+// NewConn 包装 conn，读方向受 read 限速、写方向受 write 限速，为 nil 的方向不限速
+func NewConn(conn net.Conn, read, write *TokenBucket) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Conn{Conn: conn, cancel: cancel}
+	if read != nil {
+		c.reader = NewReader(ctx, conn, read)
+	}
+	if write != nil {
+		c.writer = NewWriter(ctx, conn, write)
+	}
+	return c
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if c.reader == nil {
+		return c.Conn.Read(p)
+	}
+	return c.reader.Read(p)
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	if c.writer == nil {
+		return c.Conn.Write(p)
+	}
+	return c.writer.Write(p)
+}
+
+// Close 关闭底层连接并取消正在进行的等待，重复调用只会生效一次
+func (c *Conn) Close() error {
+	err := net.ErrClosed
+	c.once.Do(func() {
+		c.cancel()
+		err = c.Conn.Close()
+		if c.onDone != nil {
+			c.onDone()
+		}
+	})
+	return err
+}
+
+// ListenerLimits 描述 Listener 的各项限制，零值表示不限制
+type ListenerLimits struct {
+	AcceptRate *TokenBucket // 接受新连接的速率
+	MaxConns   int          // 同时打开的连接数上限
+	Read       *TokenBucket // 所有连接共享的读带宽
+	Write      *TokenBucket // 所有连接共享的写带宽
+}
+
+// Listener 是限制接受速率、并发连接数和连接带宽的 net.Listener
+type Listener struct {
+	net.Listener
+	limits ListenerLimits
+	slots  chan struct{} // 容量为 MaxConns 的信号量，为 nil 表示不限制
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewListener 包装 ln。并发连接达到上限时 Accept 会阻塞，直到有连接被关闭。
+func NewListener(ln net.Listener, limits ListenerLimits) *Listener {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Listener{Listener: ln, limits: limits, ctx: ctx, cancel: cancel}
+	if limits.MaxConns > 0 {
+		l.slots = make(chan struct{}, limits.MaxConns)
+	}
+	return l
+}
+
+// Accept 依次等待连接名额和接受速率的令牌，再接受一个连接。返回的连接关闭时归还名额。
+func (l *Listener) Accept() (net.Conn, error) {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-l.ctx.Done():
+			return nil, net.ErrClosed
+		}
+	}
+	release := func() {
+		if l.slots != nil {
+			<-l.slots
+		}
+	}
+
+	if l.limits.AcceptRate != nil {
+		if err := l.limits.AcceptRate.Wait(l.ctx); err != nil {
+			release()
+			return nil, net.ErrClosed
+		}
+	}
+
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		release()
+		return nil, err
+	}
+	c := NewConn(conn, l.limits.Read, l.limits.Write)
+	c.onDone = release
+	return c, nil
+}
+
+// Close 关闭底层 listener，并让正在等待名额或令牌的 Accept 返回 net.ErrClosed
+func (l *Listener) Close() error {
+	l.cancel()
+	return l.Listener.Close()
+}
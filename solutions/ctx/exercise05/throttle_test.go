@@ -0,0 +1,239 @@
+package exercise05
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// drive 在 done 关闭之前不断把时钟推进 step，返回经过的虚拟时间
+func drive(t *testing.T, clock *ManualClock, step time.Duration, done <-chan struct{}) time.Duration {
+	t.Helper()
+	start := clock.Now()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		select {
+		case <-done:
+			return clock.Now().Sub(start)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("transfer did not finish within 5s of real time")
+		}
+		if clock.Timers() == 0 {
+			time.Sleep(100 * time.Microsecond)
+			continue
+		}
+		clock.Advance(step)
+	}
+}
+
+// TestWriter_Throughput tests that a throttled writer moves bytes at the bucket's rate.
+func TestWriter_Throughput(t *testing.T) {
+	// 1000 B/s，突发 100 B：前 100 字节立即写出，剩下 900 字节需要 0.9 秒
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucketWithBurst(1000, 100, WithClock(clock))
+	var buf bytes.Buffer
+	w := NewWriter(context.Background(), &buf, tb)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if n, err := w.Write(make([]byte, 1000)); n != 1000 || err != nil {
+			t.Errorf("expected to write 1000 bytes, wrote %d: %v", n, err)
+		}
+	}()
+
+	elapsed := drive(t, clock, 10*time.Millisecond, done)
+	if buf.Len() != 1000 {
+		t.Errorf("expected 1000 bytes in the buffer, got %d", buf.Len())
+	}
+	if elapsed != 900*time.Millisecond {
+		t.Errorf("expected 900ms of virtual time, took %v", elapsed)
+	}
+}
+
+// TestReader_Throughput tests that a throttled reader delivers bytes at the bucket's rate.
+func TestReader_Throughput(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucketWithBurst(1000, 100, WithClock(clock))
+	r := NewReader(context.Background(), bytes.NewReader(make([]byte, 2100)), tb)
+
+	done := make(chan struct{})
+	var n int64
+	go func() {
+		defer close(done)
+		n, _ = io.Copy(io.Discard, r)
+	}()
+
+	elapsed := drive(t, clock, 10*time.Millisecond, done)
+	if n != 2100 {
+		t.Errorf("expected to read 2100 bytes, read %d", n)
+	}
+	if elapsed != 2*time.Second {
+		t.Errorf("expected 2s of virtual time, took %v", elapsed)
+	}
+}
+
+// TestWriter_SharedBucket tests that writers sharing a bucket share its bandwidth.
+func TestWriter_SharedBucket(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	tb := NewTokenBucketWithBurst(1000, 100, WithClock(clock))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := NewWriter(context.Background(), io.Discard, tb)
+			if _, err := w.Write(make([]byte, 500)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// 4 个写入方共 2000 字节，共享 1000 B/s，除去 100 字节突发需要 1.9 秒
+	if elapsed := drive(t, clock, 10*time.Millisecond, done); elapsed != 1900*time.Millisecond {
+		t.Errorf("expected 1.9s of virtual time for 2000 shared bytes, took %v", elapsed)
+	}
+}
+
+// TestWriter_Cancel tests that a blocked write returns when its context is cancelled.
+func TestWriter_Cancel(t *testing.T) {
+	tb := NewTokenBucketWithBurst(Every(time.Hour), 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	var buf bytes.Buffer
+	w := NewWriter(ctx, &buf, tb)
+
+	done := make(chan struct{})
+	var n int
+	var err error
+	go func() {
+		defer close(done)
+		n, err = w.Write(make([]byte, 25))
+	}()
+	waitQueued(t, tb, 1)
+	cancel()
+	<-done
+
+	if !errors.Is(err, context.Canceled) || n != 10 {
+		t.Errorf("expected 10 bytes and context.Canceled, got %d, %v", n, err)
+	}
+}
+
+// TestConn_Close tests that closing a throttled connection unblocks a pending write.
+func TestConn_Close(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	tb := NewTokenBucketWithBurst(Every(time.Hour), 4)
+	conn := NewConn(client, nil, tb)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte("hello, world"))
+		done <- err
+	}()
+	waitQueued(t, tb, 1)
+	conn.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the pending write to be cancelled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not unblock the pending write")
+	}
+}
+
+// TestListener_Limits tests the concurrent-connection cap and the accept rate.
+func TestListener_Limits(t *testing.T) {
+	t.Run("should block accepts beyond MaxConns until a connection closes", func(t *testing.T) {
+		inner, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln := NewListener(inner, ListenerLimits{MaxConns: 2})
+		defer ln.Close()
+
+		for i := 0; i < 3; i++ {
+			c, err := net.Dial("tcp", inner.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+		}
+
+		accepted := make(chan net.Conn, 3)
+		go func() {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- c
+			}
+		}()
+
+		first := <-accepted
+		<-accepted
+		select {
+		case <-accepted:
+			t.Fatal("expected the 3rd accept to wait for a free slot")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		first.Close()
+		select {
+		case <-accepted:
+		case <-time.After(time.Second):
+			t.Fatal("expected the 3rd accept after a connection closed")
+		}
+	})
+
+	t.Run("should pace accepts and unblock on Close", func(t *testing.T) {
+		inner, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rate := NewTokenBucketWithBurst(Every(time.Hour), 1)
+		ln := NewListener(inner, ListenerLimits{AcceptRate: rate})
+
+		for i := 0; i < 2; i++ {
+			c, err := net.Dial("tcp", inner.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+		}
+
+		c, err := ln.Accept()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer c.Close()
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := ln.Accept()
+			errs <- err
+		}()
+		waitQueued(t, rate, 1)
+		ln.Close()
+		if err := <-errs; !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected net.ErrClosed, got %v", err)
+		}
+	})
+}
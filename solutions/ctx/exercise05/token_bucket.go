@@ -1,75 +1,414 @@
-package main
+package exercise05
 
 import (
+	"container/list"
 	"context"
+	"math"
 	"sync"
 	"time"
 )
 
+// Limit 表示令牌的生成速率，单位是“每秒生成的令牌数”，允许为小数。
+// 例如 Limit(0.5) 表示每两秒一个令牌，Per(5, time.Minute) 表示每分钟 5 个。
+type Limit float64
+
+// Inf 表示不限速，任何请求都会被立即放行。
+const Inf = Limit(math.MaxFloat64)
+
+// Every 把“每隔 interval 生成一个令牌”转换为 Limit。interval <= 0 视为不限速。
+func Every(interval time.Duration) Limit {
+	if interval <= 0 {
+		return Inf
+	}
+	return 1 / Limit(interval.Seconds())
+}
+
+// Per 把“每 period 时间内生成 events 个令牌”转换为 Limit。period <= 0 视为不限速。
+func Per(events float64, period time.Duration) Limit {
+	if period <= 0 {
+		return Inf
+	}
+	return Limit(events / period.Seconds())
+}
+
+// durationFromTokens 计算按当前速率生成 tokens 个令牌需要的时间。
+// 结果精确到纳秒并向上取整，保证等待结束时令牌一定已经足够，不会提前醒来再空转一轮。
+func (limit Limit) durationFromTokens(tokens float64) time.Duration {
+	if limit <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	nanos := math.Ceil(tokens / float64(limit) * float64(time.Second))
+	if nanos >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(nanos)
+}
+
+// tokensFromDuration 计算在 d 时间内按当前速率能生成多少令牌
+func (limit Limit) tokensFromDuration(d time.Duration) float64 {
+	if limit <= 0 {
+		return 0
+	}
+	return d.Seconds() * float64(limit)
+}
+
 // TokenBucket 实现了一个令牌桶算法的速率限制器
 type TokenBucket struct {
-	ratePerSecond int       // 每秒生成的令牌数
-	maxTokens     float64   // 桶的最大容量
+	limit         Limit     // 令牌生成速率
+	maxTokens     float64   // 桶的最大容量，即允许的突发量
 	currentTokens float64   // 当前桶中的令牌数
 	lastTimestamp time.Time // 上次取令牌的时间
+	waiters       list.List // 按到达顺序排队的 *waiter
+	timer         Timer     // 唯一的定时器，在队首等待者的令牌攒够时触发
+	clock         Clock
 	mu            sync.Mutex
 }
 
-// NewTokenBucket 创建一个新的令牌桶实例
-func NewTokenBucket(ratePerSecond int) *TokenBucket {
-	maxTokens := float64(ratePerSecond)
-	return &TokenBucket{
-		ratePerSecond: ratePerSecond,
-		maxTokens:     maxTokens,
-		currentTokens: maxTokens, // 启动时令牌桶是满的
-		lastTimestamp: time.Now(),
+// waiter 是 WaitN 中排队等待令牌的调用方
+type waiter struct {
+	tokens  float64
+	ready   chan struct{} // 令牌分配给该等待者或确定永远无法满足后关闭
+	granted bool
+	err     error // 永远无法满足时为 ErrExceedsBurst
+}
+
+// NewTokenBucket 创建一个新的令牌桶实例，每秒生成 ratePerSecond 个令牌
+func NewTokenBucket(ratePerSecond int, opts ...Option) *TokenBucket {
+	return NewTokenBucketWithLimit(Limit(ratePerSecond), opts...)
+}
+
+// NewTokenBucketWithLimit 以任意速率创建令牌桶，桶容量为一秒的令牌量，但至少能容纳一个令牌，
+// 否则像 0.5/s 这样的速率永远攒不够一个令牌。
+func NewTokenBucketWithLimit(limit Limit, opts ...Option) *TokenBucket {
+	burst := 1
+	if limit != Inf && limit > 1 {
+		burst = int(limit)
 	}
+	return NewTokenBucketWithBurst(limit, burst, opts...)
 }
 
-// WaitAndTake 会阻塞直到从桶中获取一个令牌，或者 context 被取消。
-// 如果成功获取令牌，返回 nil。如果因 context 取消而中断，返回 ctx.Err()。
-func (tb *TokenBucket) WaitAndTake(ctx context.Context) error {
+// NewTokenBucketWithBurst 创建一个速率与突发量相互独立的令牌桶。
+// burst 为桶的容量，burst <= 0 时除非速率为 Inf，否则任何请求都无法获得令牌。
+func NewTokenBucketWithBurst(limit Limit, burst int, opts ...Option) *TokenBucket {
+	tb := &TokenBucket{}
+	tb.init(limit, burst, applyOptions(opts).clock)
+	return tb
+}
+
+// init 初始化一个零值令牌桶，供需要把令牌桶嵌入其他结构体以减少内存分配的调用方使用
+func (tb *TokenBucket) init(limit Limit, burst int, clock Clock) {
+	maxTokens := math.Max(0, float64(burst))
+	tb.limit = limit
+	tb.maxTokens = maxTokens
+	tb.currentTokens = maxTokens // 启动时令牌桶是满的
+	tb.lastTimestamp = clock.Now()
+	tb.clock = clock
+}
+
+// Limit 返回令牌桶当前的速率
+func (tb *TokenBucket) Limit() Limit {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
+	return tb.limit
+}
 
-	// 使用循环，以应对等待后可能的状态变化
-	for {
-		// 检查 context 是否已取消
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
+// Burst 返回令牌桶当前的容量
+func (tb *TokenBucket) Burst() int {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return int(tb.maxTokens)
+}
 
-		// 计算并补充令牌
-		now := time.Now()
-		tokensGenerated := now.Sub(tb.lastTimestamp).Seconds() * float64(tb.ratePerSecond)
-		tb.currentTokens += tokensGenerated
+// Tokens 返回当前可用的令牌数（已按经过的时间补充）
+func (tb *TokenBucket) Tokens() float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.advance(tb.clock.Now())
+	return tb.currentTokens
+}
+
+// SetRate 修改令牌生成速率，立即对正在等待的调用方生效。
+// 修改前先按旧速率结算到当前时刻，已经积累的令牌不会丢失。
+func (tb *TokenBucket) SetRate(limit Limit) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.advance(tb.clock.Now())
+	tb.limit = limit
+	tb.dispatch()
+}
+
+// SetBurst 修改桶的容量，立即对正在等待的调用方生效。
+// 扩容时保留已有令牌；缩容时超出新容量的令牌会被丢弃。
+func (tb *TokenBucket) SetBurst(burst int) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.advance(tb.clock.Now())
+	tb.maxTokens = math.Max(0, float64(burst))
+	if tb.currentTokens > tb.maxTokens {
+		tb.currentTokens = tb.maxTokens
+	}
+	tb.dispatch()
+}
+
+// advance 按照距离上次补充经过的时间补充令牌，调用方需持有锁
+func (tb *TokenBucket) advance(now time.Time) {
+	elapsed := now.Sub(tb.lastTimestamp)
+	if elapsed > 0 {
+		tb.currentTokens += tb.limit.tokensFromDuration(elapsed)
 		if tb.currentTokens > tb.maxTokens {
 			tb.currentTokens = tb.maxTokens
 		}
-		tb.lastTimestamp = now
+	}
+	tb.lastTimestamp = now
+}
 
-		// 如果令牌足够，取走一个并返回
-		if tb.currentTokens >= 1 {
-			tb.currentTokens--
-			return nil
-		}
+// Allow 是 AllowN(1) 的简写
+func (tb *TokenBucket) Allow() bool {
+	return tb.AllowN(1)
+}
 
-		// 如果令牌仍然不足，计算需要等待多久
-		timeToWait := time.Duration((1-tb.currentTokens)/float64(tb.ratePerSecond)) * time.Second
+// AllowN 尝试立即取走 n 个令牌，不会阻塞。令牌足够时取走并返回 true，否则不做任何修改并返回 false。
+// 已经有调用方在 WaitN 中排队时 AllowN 不会插队，直接返回 false。
+func (tb *TokenBucket) AllowN(n int) bool {
+	ok, _ := tb.allowN(n)
+	return ok
+}
+
+// allowN 与 AllowN 相同，同时返回判断之后桶的状态，供需要向客户端报告剩余额度的调用方使用
+func (tb *TokenBucket) allowN(n int) (bool, bucketState) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.limit == Inf || n <= 0 {
+		return true, tb.stateLocked()
+	}
+	tb.advance(tb.clock.Now())
+	if tb.waiters.Len() == 0 && tb.currentTokens >= float64(n) {
+		tb.currentTokens -= float64(n)
+		return true, tb.stateLocked()
+	}
+	return false, tb.stateLocked()
+}
 
-		// 在等待时，同时监听 context 的取消信号
+// idle 判断令牌桶是否处于空闲状态：桶已经满了且没有人在等待。
+// 空闲的令牌桶与新建的令牌桶行为完全相同，可以被安全地丢弃。
+func (tb *TokenBucket) idle() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.advance(tb.clock.Now())
+	return tb.waiters.Len() == 0 && tb.currentTokens >= tb.maxTokens
+}
+
+// bucketState 是令牌桶在某一时刻的快照
+type bucketState struct {
+	limit  Limit
+	burst  float64
+	tokens float64
+}
+
+// stateLocked 返回当前状态的快照，调用方需持有锁
+func (tb *TokenBucket) stateLocked() bucketState {
+	return bucketState{limit: tb.limit, burst: tb.maxTokens, tokens: tb.currentTokens}
+}
+
+// Wait 是 WaitN(ctx, 1) 的简写，用于实现 Limiter 接口
+func (tb *TokenBucket) Wait(ctx context.Context) error {
+	return tb.WaitN(ctx, 1)
+}
+
+// WaitAndTake 会阻塞直到从桶中获取一个令牌，或者 context 被取消。
+// 如果成功获取令牌，返回 nil。如果因 context 取消而中断，返回 ctx.Err()。
+func (tb *TokenBucket) WaitAndTake(ctx context.Context) error {
+	return tb.WaitN(ctx, 1)
+}
+
+// WaitN 会阻塞直到从桶中一次性获取 n 个令牌，或者 context 被取消。
+// 等待者严格按到达顺序获得令牌：队首拿不到令牌时，后到的调用方即使需要的令牌更少也不会插队。
+// 速率为 Inf 时立即返回；n 超过容量，或速率为 0 且令牌不足时永远无法满足，立即返回 ErrExceedsBurst。
+// 排队期间 SetRate/SetBurst 让请求变得无法满足时，同样返回 ErrExceedsBurst，不会堵住后面的等待者。
+func (tb *TokenBucket) WaitN(ctx context.Context, n int) error {
+	// 检查 context 是否已取消
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	tb.mu.Lock()
+	if tb.limit == Inf || n <= 0 {
+		tb.mu.Unlock()
+		return nil
+	}
+
+	// 没有人排队且令牌足够时直接取走，不进入队列
+	tb.advance(tb.clock.Now())
+	need := float64(n)
+	if tb.waiters.Len() == 0 && tb.currentTokens >= need {
+		tb.currentTokens -= need
+		tb.mu.Unlock()
+		return nil
+	}
+	if tb.unsatisfiable(need) {
 		tb.mu.Unlock()
-		select {
-		case <-time.After(timeToWait):
-			// 等待结束，重新加锁并进入下一次循环检查
-			tb.mu.Lock()
-			continue
-		case <-ctx.Done():
-			// 在等待期间被取消，重新加锁以保护 defer 的 Unlock，然后返回错误
-			tb.mu.Lock()
-			return ctx.Err()
+		return ErrExceedsBurst
+	}
+
+	// 否则排到队尾，由 dispatch 在令牌足够时按顺序唤醒
+	w := &waiter{tokens: need, ready: make(chan struct{})}
+	elem := tb.waiters.PushBack(w)
+	if tb.waiters.Len() == 1 {
+		tb.schedule()
+	}
+	tb.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.err
+	case <-ctx.Done():
+		tb.mu.Lock()
+		defer tb.mu.Unlock()
+		if w.granted && w.err == nil {
+			// 取消与分配同时发生，把已分配的令牌还回去
+			tb.currentTokens += w.tokens
+			if tb.currentTokens > tb.maxTokens {
+				tb.currentTokens = tb.maxTokens
+			}
+		} else if !w.granted {
+			tb.waiters.Remove(elem)
+		}
+		// 队首离开或令牌被归还后，后面的等待者可能已经可以被满足
+		tb.dispatch()
+		return ctx.Err()
+	}
+}
+
+// dispatch 按到达顺序把令牌分配给等待者，并为新的队首重新设置定时器，调用方需持有锁
+func (tb *TokenBucket) dispatch() {
+	tb.advance(tb.clock.Now())
+	for tb.waiters.Len() > 0 {
+		front := tb.waiters.Front()
+		w := front.Value.(*waiter)
+		if tb.limit != Inf {
+			if tb.unsatisfiable(w.tokens) {
+				// 限制调整后再也等不到令牌，让它出队，免得堵住后面的等待者
+				w.err = ErrExceedsBurst
+			} else if tb.currentTokens < w.tokens {
+				break
+			} else {
+				tb.currentTokens -= w.tokens
+			}
 		}
+		w.granted = true
+		close(w.ready)
+		tb.waiters.Remove(front)
+	}
+	tb.schedule()
+}
+
+// unsatisfiable 判断一次取 need 个令牌是否永远无法满足：超过容量，或速率为 0 且令牌不足。调用方需持有锁
+func (tb *TokenBucket) unsatisfiable(need float64) bool {
+	return need > tb.maxTokens || (tb.limit <= 0 && tb.currentTokens < need)
+}
+
+// schedule 让唯一的定时器在队首等待者的令牌攒够时触发，调用方需持有锁。
+// 队列为空时不需要定时器；dispatch 保证队首总能在有限时间内满足。
+func (tb *TokenBucket) schedule() {
+	if tb.waiters.Len() == 0 {
+		if tb.timer != nil {
+			tb.timer.Stop()
+		}
+		return
+	}
+	need := tb.waiters.Front().Value.(*waiter).tokens
+
+	// 纳秒精度计算等待时间，亚秒级的等待不会被截断为 0
+	wait := tb.limit.durationFromTokens(need - tb.currentTokens)
+	if tb.timer == nil {
+		tb.timer = tb.clock.AfterFunc(wait, tb.wake)
+		return
+	}
+	tb.timer.Reset(wait)
+}
+
+// wake 是定时器的回调，唤醒队首及之后所有令牌已经足够的等待者
+func (tb *TokenBucket) wake() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.dispatch()
+}
+
+// Reservation 表示从令牌桶中预订的一批令牌。
+// 令牌在预订时就已经扣除（桶中的令牌数可以因此变为负数），调用方需要等待 Delay() 之后再执行，
+// 或者调用 Cancel() 放弃执行并归还令牌。
+type Reservation struct {
+	tb        *TokenBucket
+	ok        bool
+	tokens    float64
+	timeToAct time.Time
+	canceled  bool
+}
+
+// Reserve 预订 n 个令牌并立即返回，不会阻塞。预订不参与 WaitN 的排队，按当前令牌数立即结算。
+// 当 n 超过桶的容量，或者速率为 0 且令牌不足时，预订失败，返回的 Reservation.OK() 为 false，
+// 此时不会扣除任何令牌。
+func (tb *TokenBucket) Reserve(n int) *Reservation {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.clock.Now()
+	if tb.limit == Inf || n <= 0 {
+		return &Reservation{tb: tb, ok: true, timeToAct: now}
+	}
+
+	tb.advance(now)
+	need := float64(n)
+	if tb.unsatisfiable(need) {
+		return &Reservation{tb: tb, ok: false}
+	}
+
+	tb.currentTokens -= need
+	r := &Reservation{tb: tb, ok: true, tokens: need, timeToAct: now}
+	if tb.currentTokens < 0 {
+		r.timeToAct = now.Add(tb.limit.durationFromTokens(-tb.currentTokens))
+	}
+	return r
+}
+
+// OK 返回预订是否成功。预订失败时 Delay 没有意义，Cancel 是空操作。
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay 返回距离可以执行还需等待的时间，0 表示可以立即执行
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return time.Duration(math.MaxInt64)
+	}
+	delay := r.timeToAct.Sub(r.tb.clock.Now())
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Cancel 放弃这次预订，把令牌归还给令牌桶并分配给排队中的等待者。
+// 重复调用是安全的；已经按预订执行了操作的调用方不应再调用 Cancel。
+func (r *Reservation) Cancel() {
+	if !r.ok || r.tokens == 0 {
+		return
+	}
+	tb := r.tb
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if r.canceled {
+		return
+	}
+	r.canceled = true
+	tb.advance(tb.clock.Now())
+	tb.currentTokens += r.tokens
+	if tb.currentTokens > tb.maxTokens {
+		tb.currentTokens = tb.maxTokens
 	}
+	tb.dispatch()
 }
@@ -0,0 +1,603 @@
+package exercise05
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLimit_Conversions tests the helpers that build fractional and arbitrary-period limits.
+func TestLimit_Conversions(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit Limit
+		want  Limit
+	}{
+		{"5 per minute", Per(5, time.Minute), Limit(5.0 / 60)},
+		{"every 2 seconds", Every(2 * time.Second), 0.5},
+		{"every 100ms", Every(100 * time.Millisecond), 10},
+		{"non-positive interval", Every(0), Inf},
+		{"non-positive period", Per(1, 0), Inf},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := float64(tt.limit - tt.want); diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("expected limit %v, got %v", tt.want, tt.limit)
+			}
+		})
+	}
+}
+
+// TestLimit_DurationFromTokens tests that waits keep nanosecond precision instead of
+// being truncated to whole seconds.
+func TestLimit_DurationFromTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  Limit
+		tokens float64
+		want   time.Duration
+	}{
+		{"sub-second wait", 10, 1, 100 * time.Millisecond},
+		{"fractional token", 10, 0.25, 25 * time.Millisecond},
+		{"rounded up to the next nanosecond", 3, 1, 333333334 * time.Nanosecond},
+		{"fractional rate", 0.5, 1, 2 * time.Second},
+		{"per minute", Per(5, time.Minute), 1, 12 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limit.durationFromTokens(tt.tokens); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// countingClock 统计通过 AfterFunc 创建的定时器数量和回调次数
+type countingClock struct {
+	Clock
+	timers, wakeups int64
+}
+
+func (c *countingClock) AfterFunc(d time.Duration, f func()) Timer {
+	atomic.AddInt64(&c.timers, 1)
+	return c.Clock.AfterFunc(d, func() {
+		atomic.AddInt64(&c.wakeups, 1)
+		f()
+	})
+}
+
+// TestTokenBucket_NoSpinning tests that WaitAndTake sleeps once per missing token
+// instead of busy-looping on zero-length timers.
+func TestTokenBucket_NoSpinning(t *testing.T) {
+	t.Run("should wake at most once per sub-second wait", func(t *testing.T) {
+		// 1. 设置：包装手动时钟，统计定时器的创建和唤醒次数
+		manual := NewManualClock(time.Unix(0, 0))
+		clock := &countingClock{Clock: manual}
+		// 每 5ms 一个令牌，桶容量为 1
+		const takes = 20
+		tb := NewTokenBucketWithBurst(Every(5*time.Millisecond), 1, WithClock(clock))
+		if err := tb.WaitAndTake(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// 2. 执行：每个等待者排队后把时钟推进一个令牌的时间，它应该恰好在这时被唤醒
+		for i := 1; i < takes; i++ {
+			done := make(chan error, 1)
+			go func() { done <- tb.WaitAndTake(context.Background()) }()
+			waitQueued(t, tb, 1)
+			manual.Advance(5 * time.Millisecond)
+			if err := <-done; err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		// 3. 断言：第一个令牌来自满桶，其余每个令牌只唤醒一次，且始终复用同一个定时器
+		if got := atomic.LoadInt64(&clock.wakeups); got != takes-1 {
+			t.Errorf("expected %d wakeups, got %d", takes-1, got)
+		}
+		if got := atomic.LoadInt64(&clock.timers); got != 1 {
+			t.Errorf("expected a single reused timer, got %d", got)
+		}
+	})
+}
+
+// TestTokenBucket_ZeroAndInfiniteLimit tests the two degenerate rates.
+func TestTokenBucket_ZeroAndInfiniteLimit(t *testing.T) {
+	t.Run("infinite limit never blocks", func(t *testing.T) {
+		tb := NewTokenBucketWithLimit(Inf)
+		for i := 0; i < 10000; i++ {
+			if err := tb.WaitAndTake(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	})
+
+	t.Run("zero limit serves the initial token then fails", func(t *testing.T) {
+		tb := NewTokenBucketWithLimit(0)
+		if err := tb.WaitAndTake(context.Background()); err != nil {
+			t.Fatalf("expected the initial token, got %v", err)
+		}
+		if err := tb.WaitAndTake(context.Background()); !errors.Is(err, ErrExceedsBurst) {
+			t.Errorf("expected ErrExceedsBurst, got %v", err)
+		}
+	})
+}
+
+// TestTokenBucket_Burst tests that burst and rate are configured independently.
+func TestTokenBucket_Burst(t *testing.T) {
+	t.Run("should allow exactly burst tokens up front", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(Every(time.Hour), 3)
+		for i := 0; i < 3; i++ {
+			if err := tb.WaitAndTake(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := tb.WaitAndTake(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the 4th token to be unavailable, got %v", err)
+		}
+	})
+
+	t.Run("default burst keeps one second of tokens", func(t *testing.T) {
+		if got := NewTokenBucket(10).Burst(); got != 10 {
+			t.Errorf("expected burst 10, got %d", got)
+		}
+		if got := NewTokenBucketWithLimit(0.5).Burst(); got != 1 {
+			t.Errorf("expected burst 1, got %d", got)
+		}
+	})
+}
+
+// TestTokenBucket_SetRate tests that rate changes apply to current waiters and keep accrued tokens.
+func TestTokenBucket_SetRate(t *testing.T) {
+	t.Run("should fail a waiter that a zero rate can never serve", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(Every(time.Hour), 1)
+		if err := tb.WaitAndTake(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- tb.WaitAndTake(context.Background()) }()
+		waitQueued(t, tb, 1)
+
+		tb.SetRate(0)
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrExceedsBurst) {
+				t.Errorf("expected ErrExceedsBurst, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiter kept waiting on a zero rate")
+		}
+	})
+
+	t.Run("should shorten the wait of a current waiter", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		tb := NewTokenBucketWithBurst(Every(time.Hour), 1, WithClock(clock))
+		if err := tb.WaitAndTake(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- tb.WaitAndTake(context.Background()) }()
+		waitQueued(t, tb, 1)
+
+		tb.SetRate(Every(10 * time.Millisecond))
+		clock.Advance(10 * time.Millisecond)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiter kept the old one-hour wait after SetRate")
+		}
+	})
+
+	t.Run("should keep tokens accrued at the old rate", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		tb := NewTokenBucketWithBurst(100, 10, WithClock(clock))
+		for i := 0; i < 10; i++ {
+			if err := tb.WaitAndTake(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		clock.Advance(50 * time.Millisecond) // 按 100/s 积累 5 个令牌
+
+		tb.SetRate(0)
+		clock.Advance(time.Second)
+		if got := tb.Tokens(); got != 5 {
+			t.Errorf("expected the 5 accrued tokens to be kept after SetRate, got %.2f", got)
+		}
+	})
+}
+
+// TestTokenBucket_SetBurst tests resizing the bucket at runtime.
+func TestTokenBucket_SetBurst(t *testing.T) {
+	t.Run("should keep tokens when growing and clamp when shrinking", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(0, 5)
+
+		tb.SetBurst(10)
+		if got := tb.Tokens(); got != 5 {
+			t.Errorf("expected 5 tokens after growing the burst, got %v", got)
+		}
+
+		tb.SetBurst(2)
+		if got := tb.Tokens(); got != 2 {
+			t.Errorf("expected 2 tokens after shrinking the burst, got %v", got)
+		}
+	})
+
+	t.Run("should fail a waiter when the burst shrinks below its request", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(Every(time.Hour), 3)
+		if err := tb.WaitN(context.Background(), 3); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- tb.WaitN(context.Background(), 3) }()
+		waitQueued(t, tb, 1)
+
+		tb.SetBurst(2)
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrExceedsBurst) {
+				t.Errorf("expected ErrExceedsBurst, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiter kept waiting for more tokens than the burst")
+		}
+	})
+}
+
+// TestTokenBucket_AllowN tests the non-blocking API.
+func TestTokenBucket_AllowN(t *testing.T) {
+	t.Run("should take tokens only when enough are available", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(Every(time.Hour), 5)
+
+		if !tb.AllowN(3) {
+			t.Fatal("expected AllowN(3) to succeed on a full bucket of 5")
+		}
+		if tb.AllowN(3) {
+			t.Fatal("expected AllowN(3) to fail with 2 tokens left")
+		}
+		if !tb.Allow() || !tb.Allow() {
+			t.Fatal("expected the 2 remaining tokens to be allowed")
+		}
+		if tb.Allow() {
+			t.Error("expected an empty bucket to reject")
+		}
+	})
+
+	t.Run("infinite limit always allows", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(Inf, 0)
+		if !tb.AllowN(1000) {
+			t.Error("expected an infinite limit to allow any n")
+		}
+	})
+}
+
+// TestTokenBucket_WaitN tests waiting for several tokens at once.
+func TestTokenBucket_WaitN(t *testing.T) {
+	t.Run("should wait until n tokens have accrued", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		tb := NewTokenBucketWithBurst(Every(5*time.Millisecond), 4, WithClock(clock))
+		if err := tb.WaitN(context.Background(), 4); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- tb.WaitN(context.Background(), 4) }()
+		waitQueued(t, tb, 1)
+		clock.Advance(15 * time.Millisecond)
+		if n := queued(tb); n != 1 {
+			t.Fatalf("expected the waiter to still need a token after 15ms, %d queued", n)
+		}
+		clock.Advance(5 * time.Millisecond)
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("n above burst fails immediately", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(1000, 2)
+		if err := tb.WaitN(context.Background(), 3); !errors.Is(err, ErrExceedsBurst) {
+			t.Errorf("expected ErrExceedsBurst, got %v", err)
+		}
+	})
+
+	t.Run("zero rate without enough tokens fails immediately", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(0, 2)
+		if err := tb.WaitN(context.Background(), 2); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := tb.WaitN(context.Background(), 1); !errors.Is(err, ErrExceedsBurst) {
+			t.Errorf("expected ErrExceedsBurst, got %v", err)
+		}
+	})
+
+	// 回归测试：永远无法满足的等待者不能排在队首挡住后面的调用方
+	t.Run("impossible waiter does not block others", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(1000, 2)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		impossible := make(chan error, 1)
+		go func() { impossible <- tb.WaitN(ctx, 3) }()
+		if err := <-impossible; !errors.Is(err, ErrExceedsBurst) {
+			t.Fatalf("expected ErrExceedsBurst, got %v", err)
+		}
+		if !tb.Allow() {
+			t.Error("expected Allow on a full bucket to succeed")
+		}
+		if err := tb.WaitN(ctx, 1); err != nil {
+			t.Errorf("expected WaitN(1) to succeed, got %v", err)
+		}
+	})
+}
+
+// TestTokenBucket_Reserve tests reservations, their delay and cancellation.
+func TestTokenBucket_Reserve(t *testing.T) {
+	t.Run("should report the delay until the tokens are available", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		tb := NewTokenBucketWithBurst(Every(100*time.Millisecond), 2, WithClock(clock))
+
+		r1 := tb.Reserve(2)
+		if !r1.OK() || r1.Delay() != 0 {
+			t.Fatalf("expected an immediate reservation, got ok=%v delay=%v", r1.OK(), r1.Delay())
+		}
+
+		r2 := tb.Reserve(2)
+		if !r2.OK() {
+			t.Fatal("expected the second reservation to succeed")
+		}
+		if d := r2.Delay(); d != 200*time.Millisecond {
+			t.Errorf("expected a delay of 200ms, got %v", d)
+		}
+		clock.Advance(150 * time.Millisecond)
+		if d := r2.Delay(); d != 50*time.Millisecond {
+			t.Errorf("expected a delay of 50ms after 150ms, got %v", d)
+		}
+	})
+
+	t.Run("cancel should give the tokens back", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(Every(time.Hour), 3)
+
+		r := tb.Reserve(3)
+		if tb.Allow() {
+			t.Fatal("expected the bucket to be empty after reserving all tokens")
+		}
+		r.Cancel()
+		r.Cancel() // 重复取消不应重复归还
+		if got := tb.Tokens(); got < 3 || got > 3.001 {
+			t.Errorf("expected 3 tokens after cancel, got %v", got)
+		}
+	})
+
+	t.Run("cancel should wake waiters", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(Every(time.Hour), 1)
+		r := tb.Reserve(1)
+
+		done := make(chan error, 1)
+		go func() { done <- tb.WaitAndTake(context.Background()) }()
+		waitQueued(t, tb, 1)
+
+		r.Cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiter was not woken by Cancel")
+		}
+	})
+
+	t.Run("should fail when n exceeds burst or the rate is zero", func(t *testing.T) {
+		if r := NewTokenBucketWithBurst(10, 2).Reserve(3); r.OK() {
+			t.Error("expected reserving more than the burst to fail")
+		}
+
+		tb := NewTokenBucketWithBurst(0, 1)
+		if r := tb.Reserve(1); !r.OK() {
+			t.Error("expected the initial token to be reservable")
+		}
+		if r := tb.Reserve(1); r.OK() {
+			t.Error("expected a zero rate to refuse a reservation it can never fill")
+		}
+	})
+}
+
+// queued 返回正在排队的等待者数量
+func queued(tb *TokenBucket) int {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return tb.waiters.Len()
+}
+
+// waitQueued 等待直到有 n 个等待者在排队
+func waitQueued(t testing.TB, tb *TokenBucket, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for queued(tb) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued waiters, got %d", n, queued(tb))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestTokenBucket_FIFO tests that concurrent waiters are served in arrival order.
+func TestTokenBucket_FIFO(t *testing.T) {
+	t.Run("should serve waiters in arrival order", func(t *testing.T) {
+		// 1. 设置：每小时一个令牌且桶初始为空，保证排队期间没有等待者被服务
+		const numWaiters = 20
+		tb := NewTokenBucketWithBurst(Every(time.Hour), 1)
+		tb.Allow()
+
+		var (
+			mu    sync.Mutex
+			order []int
+			wg    sync.WaitGroup
+		)
+
+		// 2. 执行：逐个启动等待者，确认它已经排队后再启动下一个
+		for i := 0; i < numWaiters; i++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				if err := tb.WaitAndTake(context.Background()); err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				mu.Lock()
+				order = append(order, id)
+				mu.Unlock()
+			}(i)
+			waitQueued(t, tb, i+1)
+		}
+		tb.SetRate(Every(2 * time.Millisecond))
+		wg.Wait()
+
+		// 3. 断言
+		for i, id := range order {
+			if id != i {
+				t.Fatalf("expected waiters served in arrival order, got %v", order)
+			}
+		}
+	})
+
+	t.Run("late small requests should not overtake a large head", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(Every(5*time.Millisecond), 4)
+		tb.AllowN(4)
+
+		large := make(chan struct{})
+		go func() {
+			_ = tb.WaitN(context.Background(), 4)
+			close(large)
+		}()
+		waitQueued(t, tb, 1)
+
+		if tb.Allow() {
+			t.Error("Allow should not barge ahead of a queued waiter")
+		}
+
+		small := make(chan struct{})
+		go func() {
+			_ = tb.WaitN(context.Background(), 1)
+			close(small)
+		}()
+
+		select {
+		case <-large:
+		case <-small:
+			t.Fatal("a later request for 1 token overtook the queued request for 4")
+		case <-time.After(time.Second):
+			t.Fatal("head waiter was never served")
+		}
+		<-small
+	})
+}
+
+// TestTokenBucket_CancelWaiter tests that cancelled waiters leave the queue cleanly.
+func TestTokenBucket_CancelWaiter(t *testing.T) {
+	t.Run("cancelled waiters are removed and do not consume tokens", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(Every(time.Hour), 1)
+		tb.Allow()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			go func() { errs <- tb.WaitAndTake(ctx) }()
+		}
+		waitQueued(t, tb, 10)
+
+		cancel()
+		for i := 0; i < 10; i++ {
+			if err := <-errs; !errors.Is(err, context.Canceled) {
+				t.Errorf("expected context.Canceled, got %v", err)
+			}
+		}
+		if n := queued(tb); n != 0 {
+			t.Errorf("expected an empty queue after cancellation, got %d", n)
+		}
+	})
+
+	t.Run("cancelling the head should unblock the next waiter", func(t *testing.T) {
+		tb := NewTokenBucketWithBurst(Every(time.Hour), 2)
+		tb.AllowN(1)
+
+		headCtx, cancelHead := context.WithCancel(context.Background())
+		headErr := make(chan error, 1)
+		go func() { headErr <- tb.WaitN(headCtx, 2) }()
+		waitQueued(t, tb, 1)
+
+		next := make(chan error, 1)
+		go func() { next <- tb.WaitN(context.Background(), 1) }()
+		waitQueued(t, tb, 2)
+
+		cancelHead()
+		if err := <-headErr; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+		select {
+		case err := <-next:
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the waiter behind a cancelled head was never served")
+		}
+	})
+}
+
+// BenchmarkTokenBucket_Waiters measures serving thousands of concurrent waiters.
+func BenchmarkTokenBucket_Waiters(b *testing.B) {
+	for _, numWaiters := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("waiters=%d", numWaiters), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tb := NewTokenBucketWithBurst(1e6, 100)
+				var wg sync.WaitGroup
+				wg.Add(numWaiters)
+				for j := 0; j < numWaiters; j++ {
+					go func() {
+						defer wg.Done()
+						_ = tb.WaitAndTake(context.Background())
+					}()
+				}
+				wg.Wait()
+			}
+		})
+	}
+}
+
+// BenchmarkTokenBucket_CancelWaiters measures removing thousands of cancelled waiters.
+func BenchmarkTokenBucket_CancelWaiters(b *testing.B) {
+	for _, numWaiters := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("waiters=%d", numWaiters), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tb := NewTokenBucketWithBurst(Every(time.Hour), 1)
+				tb.Allow()
+				ctx, cancel := context.WithCancel(context.Background())
+				var wg sync.WaitGroup
+				wg.Add(numWaiters)
+				for j := 0; j < numWaiters; j++ {
+					go func() {
+						defer wg.Done()
+						_ = tb.WaitAndTake(ctx)
+					}()
+				}
+				waitQueued(b, tb, numWaiters)
+				cancel()
+				wg.Wait()
+			}
+		})
+	}
+}
@@ -1,3 +1,9 @@
 module exercise09
 
-go 1.21 
\ No newline at end of file
+go 1.24.2
+
+require exercise05 v0.0.0
+
+require gopkg.in/yaml.v3 v3.0.1
+
+replace exercise05 => ../exercise05
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"sync"
+	"log"
+
+	"exercise09/pipeline"
 )
 
 // Exercise 9: Fan-in, Fan-out Pattern
@@ -31,59 +34,32 @@ import (
 // - Fan-in (one goroutine reading from multiple channels)
 
 func main() {
-	// TODO: Implement the fan-in, fan-out pipeline.
 	fmt.Println("Fan-in, Fan-out Pipeline")
-	producerChan := producer()
-	var squarerChans []<-chan int
-	for i := 0; i < 3; i++ {
-		squarerChans = append(squarerChans, squarer(producerChan))
-	}
-	mergeChan := merge(squarerChans...)
+	p := pipeline.New(context.Background())
+	producerChan := producer(p)
+	squarerChans := pipeline.FanOut(p, producerChan, 3, squarer)
+	mergeChan := pipeline.FanIn(p, squarerChans...)
 	for v := range mergeChan {
 		fmt.Println(v)
 	}
+	if err := p.Wait(); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func producer() <-chan int {
-	ch := make(chan int, 5)
-	go func() {
-		defer close(ch)
+// producer emits the numbers 1 to 1000.
+func producer(p *pipeline.Pipeline) <-chan int {
+	return pipeline.Generate(p, func(ctx context.Context, emit func(int) bool) error {
 		for i := 1; i <= 1000; i++ {
-			ch <- i
-		}
-	}()
-	return ch
-}
-
-func squarer(input <-chan int) <-chan int {
-	ch := make(chan int, 5)
-	go func() {
-		defer close(ch)
-		for v := range input {
-			ch <- v
+			if !emit(i) {
+				return nil
+			}
 		}
-	}()
-	return ch
+		return nil
+	})
 }
 
-func merge(inputs ...<-chan int) <-chan int {
-	ch := make(chan int, 5)
-	var wg sync.WaitGroup
-	wg.Add(len(inputs))
-	for _, input := range inputs {
-		input := input
-		go func() {
-			defer wg.Done()
-			for v := range input {
-				ch <- v
-			}
-		}()
-	}
-
-	go func() {
-		wg.Wait()
-		close(ch)
-	}()
-
-	return ch
-}
+// squarer is a stage that squares every number it reads.
+var squarer = pipeline.Map(func(_ context.Context, v int) (int, error) {
+	return v * v, nil
+})
@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Fields is a record made of named values, the item type the built-in stage types of
+// DefaultRegistry work on. Decoded JSON numbers are float64.
+type Fields map[string]any
+
+// DefaultRegistry returns a registry with the built-in stage types, so a definition of
+// file-to-file processing compiles without custom Go code:
+//
+//	sources  lines        string   params: path
+//	         jsonl        Fields   params: path
+//	stages   decode       string → Fields    each line is a JSON object
+//	         encode       Fields → string    as a JSON object
+//	         filter       Fields → Fields    params: field, equals (optional)
+//	         map          Fields → Fields    params: fields, a comma-separated list to keep
+//	         batch        Fields → []Fields  params: size (100), timeout (1s)
+//	         unbatch      []Fields → Fields
+//	sinks    write_lines  string   params: path
+//	         write_jsonl  Fields   params: path
+//
+// filter keeps the items that have field, or whose field prints as equals if it is set.
+// Paths ending in .gz are read and written gzipped. More types can be registered on the
+// returned registry.
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	RegisterSource(r, "lines", func(ps Params) (func(p *Pipeline) <-chan string, error) {
+		path, err := requiredString(ps, "path")
+		if err != nil {
+			return nil, err
+		}
+		return func(p *Pipeline) <-chan string { return Values[string]()(p, ReadLines(p, File(path))) }, nil
+	})
+	RegisterSource(r, "jsonl", func(ps Params) (func(p *Pipeline) <-chan Fields, error) {
+		path, err := requiredString(ps, "path")
+		if err != nil {
+			return nil, err
+		}
+		return func(p *Pipeline) <-chan Fields { return Values[Fields]()(p, ReadJSONL[Fields](p, File(path))) }, nil
+	})
+
+	RegisterStage(r, "decode", func(Params) (Stage[string, Fields], error) {
+		return Map(func(_ context.Context, line string) (Fields, error) {
+			var f Fields
+			if err := json.Unmarshal([]byte(line), &f); err != nil {
+				return nil, fmt.Errorf("decode %q: %w", line, err)
+			}
+			return f, nil
+		}), nil
+	})
+	RegisterStage(r, "encode", func(Params) (Stage[Fields, string], error) {
+		return Map(func(_ context.Context, f Fields) (string, error) {
+			b, err := json.Marshal(f)
+			return string(b), err
+		}), nil
+	})
+	RegisterStage(r, "filter", func(ps Params) (Stage[Fields, Fields], error) {
+		field, err := requiredString(ps, "field")
+		if err != nil {
+			return nil, err
+		}
+		equals, hasEquals := ps["equals"]
+		want := fmt.Sprint(equals)
+		return Filter(func(f Fields) bool {
+			v, ok := f[field]
+			return ok && (!hasEquals || fmt.Sprint(v) == want)
+		}), nil
+	})
+	RegisterStage(r, "map", func(ps Params) (Stage[Fields, Fields], error) {
+		list, err := requiredString(ps, "fields")
+		if err != nil {
+			return nil, err
+		}
+		keep := strings.Split(list, ",")
+		for i := range keep {
+			keep[i] = strings.TrimSpace(keep[i])
+		}
+		return Map(func(_ context.Context, f Fields) (Fields, error) {
+			out := make(Fields, len(keep))
+			for _, k := range keep {
+				if v, ok := f[k]; ok {
+					out[k] = v
+				}
+			}
+			return out, nil
+		}), nil
+	})
+	RegisterStage(r, "batch", func(ps Params) (Stage[Fields, []Fields], error) {
+		size, err := ps.Int("size", 100)
+		if err != nil {
+			return nil, err
+		}
+		timeout, err := ps.Duration("timeout", time.Second)
+		if err != nil {
+			return nil, err
+		}
+		if size < 1 || timeout <= 0 {
+			return nil, fmt.Errorf("batch size and timeout must be positive, got %d and %v", size, timeout)
+		}
+		return Batch[Fields](size, timeout), nil
+	})
+	RegisterStage(r, "unbatch", func(Params) (Stage[[]Fields, Fields], error) {
+		return func(p *Pipeline, in <-chan []Fields) <-chan Fields {
+			return transform(p, "unbatch", in, func(_ context.Context, batch []Fields, emit func(Fields) bool) bool {
+				for _, f := range batch {
+					if !emit(f) {
+						return false
+					}
+				}
+				return true
+			}, nil)
+		}, nil
+	})
+
+	registerSinkStage(r, "write_lines", func(ps Params) (func(p *Pipeline, in <-chan string), error) {
+		path, err := requiredString(ps, "path")
+		if err != nil {
+			return nil, err
+		}
+		return func(p *Pipeline, in <-chan string) { WriteLines(p, in, File(path)) }, nil
+	})
+	registerSinkStage(r, "write_jsonl", func(ps Params) (func(p *Pipeline, in <-chan Fields), error) {
+		path, err := requiredString(ps, "path")
+		if err != nil {
+			return nil, err
+		}
+		return func(p *Pipeline, in <-chan Fields) { WriteJSONL(p, in, File(path)) }, nil
+	})
+	return r
+}
+
+// requiredString returns the string parameter key, which must be set.
+func requiredString(ps Params, key string) (string, error) {
+	s, err := ps.String(key, "")
+	if err == nil && s == "" {
+		err = fmt.Errorf("param %s: required", key)
+	}
+	return s, err
+}
@@ -0,0 +1,242 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckpointStore persists the offset each source of a pipeline should resume from.
+type CheckpointStore interface {
+	// Load returns the saved offset of name, or 0 if nothing has been saved.
+	Load(name string) (int64, error)
+	// Save durably records offset for name.
+	Save(name string, offset int64) error
+}
+
+// DirCheckpointStore keeps each checkpoint in its own file in a directory. A save writes
+// a temporary file and renames it over the old one, so a crash leaves either the old or
+// the new offset, never a torn one.
+type DirCheckpointStore struct {
+	dir string
+}
+
+// NewDirCheckpointStore returns a store in dir, creating the directory if needed.
+func NewDirCheckpointStore(dir string) (*DirCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DirCheckpointStore{dir: dir}, nil
+}
+
+func (s *DirCheckpointStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid checkpoint name %q", name)
+	}
+	return filepath.Join(s.dir, name+".checkpoint"), nil
+}
+
+// Load implements CheckpointStore.
+func (s *DirCheckpointStore) Load(name string) (int64, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	return offset, nil
+}
+
+// Save implements CheckpointStore.
+func (s *DirCheckpointStore) Save(name string, offset int64) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, "."+name+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+	if _, err := fmt.Fprintf(tmp, "%d\n", offset); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// make the rename itself durable; not every platform can sync a directory
+	if dir, err := os.Open(s.dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// Checkpoint tracks which records of one source have been fully processed, and saves
+// the low-watermark: the offset up to which every record has been acknowledged. Records
+// acknowledged out of order beyond it are processed again after a restart, so a pipeline
+// resumed from a checkpoint delivers every record at least once.
+//
+// A typical checkpointed pipeline looks like:
+//
+//	cp, err := NewCheckpoint(store, "orders", time.Second)
+//	recs := Track(p, cp, ReadLines(p, File(path), WithOffset(cp.Offset())))
+//	... stages that keep each Record's Offset ...
+//	Sink(p, out, func(ctx context.Context, r Record[T]) error {
+//		// write r.Value durably, then
+//		cp.Ack(r.Offset)
+//		return nil
+//	})
+//	err = p.Wait()
+//	if err == nil {
+//		err = cp.Save()
+//	}
+//
+// Every record must be acknowledged, including ones a stage drops, or the watermark
+// stops advancing there.
+type Checkpoint struct {
+	store    CheckpointStore
+	name     string
+	interval time.Duration
+	start    int64
+
+	mu        sync.Mutex
+	inflight  []int64        // offsets of tracked records in source order, from the watermark on
+	acked     map[int64]bool // whether each offset in inflight has been acknowledged
+	watermark int64
+
+	saveMu sync.Mutex
+	saved  int64
+}
+
+// NewCheckpoint loads the checkpoint called name from store. While the pipeline runs,
+// Track saves the watermark every interval; an interval that is not positive leaves
+// saving to explicit Save calls.
+func NewCheckpoint(store CheckpointStore, name string, interval time.Duration) (*Checkpoint, error) {
+	offset, err := store.Load(name)
+	if err != nil {
+		return nil, fmt.Errorf("load checkpoint %s: %w", name, err)
+	}
+	return &Checkpoint{
+		store:     store,
+		name:      name,
+		interval:  interval,
+		start:     offset,
+		acked:     make(map[int64]bool),
+		watermark: offset,
+		saved:     offset,
+	}, nil
+}
+
+// Offset returns the offset the source should resume from, as loaded from the store.
+func (c *Checkpoint) Offset() int64 { return c.start }
+
+// Watermark returns the offset up to which every record has been acknowledged.
+func (c *Checkpoint) Watermark() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.watermark
+}
+
+// track registers a record before it is sent downstream.
+func (c *Checkpoint) track(offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight = append(c.inflight, offset)
+	c.acked[offset] = false
+}
+
+// Ack marks the record ending at offset as processed. Offsets that are not being
+// tracked are ignored.
+func (c *Checkpoint) Ack(offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, tracked := c.acked[offset]; !tracked {
+		return
+	}
+	c.acked[offset] = true
+	for len(c.inflight) > 0 && c.acked[c.inflight[0]] {
+		c.watermark = c.inflight[0]
+		delete(c.acked, c.watermark)
+		c.inflight = c.inflight[1:]
+	}
+}
+
+// Save writes the watermark to the store if it moved since the last save.
+func (c *Checkpoint) Save() error {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+	wm := c.Watermark()
+	if wm == c.saved {
+		return nil
+	}
+	if err := c.store.Save(c.name, wm); err != nil {
+		return fmt.Errorf("save checkpoint %s: %w", c.name, err)
+	}
+	c.saved = wm
+	return nil
+}
+
+// Track returns the records of in unchanged after registering them with c, and saves c
+// every interval until in closes. Call c.Save once the pipeline has finished to record
+// the final watermark.
+func Track[T any](p *Pipeline, c *Checkpoint, in <-chan Record[T]) <-chan Record[T] {
+	n := p.newNode("checkpoint", in)
+	out := output(p, n, make(chan Record[T]))
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		var tick <-chan time.Time // nil when only explicit Save calls persist c
+		if c.interval > 0 {
+			ticker := time.NewTicker(c.interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			waiting := time.Now()
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-n.gone:
+				return nil
+			case <-tick:
+				if err := c.Save(); err != nil {
+					return err
+				}
+			case r, ok := <-in:
+				n.waited(waiting)
+				if !ok {
+					return nil
+				}
+				n.receivedFrom(in)
+				c.track(r.Offset)
+				if !send(ctx, n, out, r) {
+					return nil
+				}
+			}
+		}
+	})
+	return out
+}
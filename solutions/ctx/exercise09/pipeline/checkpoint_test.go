@@ -0,0 +1,289 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirCheckpointStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "checkpoints")
+	store, err := NewDirCheckpointStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off, err := store.Load("orders"); err != nil || off != 0 {
+		t.Fatalf("Load before any save = %d, %v; want 0, nil", off, err)
+	}
+	for _, off := range []int64{42, 1 << 40} {
+		if err := store.Save("orders", off); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := store.Load("orders"); err != nil || got != off {
+			t.Fatalf("Load = %d, %v; want %d", got, err, off)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only the checkpoint file to remain, found %d entries", len(entries))
+	}
+	for _, name := range []string{"", "../escape", "a/b", ".hidden"} {
+		if err := store.Save(name, 1); err == nil {
+			t.Errorf("Save(%q) succeeded, want an invalid name error", name)
+		}
+	}
+}
+
+// TestCheckpoint_NoInterval is a regression test: a zero interval used to reach
+// time.NewTicker and panic inside Track. It now means saving only on explicit Save.
+func TestCheckpoint_NoInterval(t *testing.T) {
+	store, _ := NewDirCheckpointStore(t.TempDir())
+	cp, err := NewCheckpoint(store, "src", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New(context.Background())
+	recs := Track(p, cp, From(p, Record[int]{1, 10}, Record[int]{2, 20}))
+	Sink(p, recs, func(_ context.Context, r Record[int]) error {
+		cp.Ack(r.Offset)
+		return nil
+	})
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if off, _ := store.Load("src"); off != 0 {
+		t.Fatalf("checkpoint saved at %d without an interval, want nothing saved before Save", off)
+	}
+	if err := cp.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if off, _ := store.Load("src"); off != 20 {
+		t.Errorf("checkpoint at %d after Save, want 20", off)
+	}
+}
+
+// TestCheckpoint_Watermark tests that the watermark only advances over a contiguous run
+// of acknowledged records.
+func TestCheckpoint_Watermark(t *testing.T) {
+	store, _ := NewDirCheckpointStore(t.TempDir())
+	cp, err := NewCheckpoint(store, "src", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, off := range []int64{10, 20, 30, 40} {
+		cp.track(off)
+	}
+	steps := []struct {
+		ack, want int64
+	}{
+		{20, 0},  // 10 still in flight
+		{40, 0},  // out of order
+		{10, 20}, // 10 and 20 done
+		{99, 20}, // not tracked
+		{30, 40},
+	}
+	for _, s := range steps {
+		cp.Ack(s.ack)
+		if got := cp.Watermark(); got != s.want {
+			t.Fatalf("after ack %d: watermark %d, want %d", s.ack, got, s.want)
+		}
+	}
+	// acks for offsets that were never tracked must not pile up
+	for off := int64(100); off < 200; off++ {
+		cp.Ack(off)
+	}
+	if n := len(cp.acked); n != 0 {
+		t.Errorf("%d acks kept for untracked offsets, want none", n)
+	}
+	if err := cp.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if resumed, _ := NewCheckpoint(store, "src", time.Hour); resumed.Offset() != 40 {
+		t.Errorf("resumed at %d, want 40", resumed.Offset())
+	}
+}
+
+const (
+	ckptLines   = 2000
+	ckptLineLen = len("line-00000\n")
+)
+
+func writeCheckpointInput(t *testing.T, path string) {
+	var b strings.Builder
+	for i := 0; i < ckptLines; i++ {
+		fmt.Fprintf(&b, "line-%05d\n", i)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runCheckpointed copies the lines of in to out in upper case through parallel workers,
+// acknowledging each line once it has been appended to out. failAfter > 0 makes the sink
+// fail after that many lines, like a crash that skips the final save.
+func runCheckpointed(ctx context.Context, in, out, dir string, delay time.Duration, failAfter int) error {
+	store, err := NewDirCheckpointStore(dir)
+	if err != nil {
+		return err
+	}
+	cp, err := NewCheckpoint(store, "input", 5*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(out, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p := New(ctx)
+	recs := Track(p, cp, ReadLines(p, File(in), WithOffset(cp.Offset())))
+	upper := Parallel(4, Map(func(_ context.Context, r Record[string]) (Record[string], error) {
+		time.Sleep(delay)
+		return Record[string]{strings.ToUpper(r.Value), r.Offset}, nil
+	}))(p, recs)
+	written := 0
+	Sink(p, upper, func(_ context.Context, r Record[string]) error {
+		if failAfter > 0 && written == failAfter {
+			return errCrash
+		}
+		// one unbuffered write per line, so a killed process loses no acknowledged line
+		if _, err := f.WriteString(r.Value + "\n"); err != nil {
+			return err
+		}
+		written++
+		cp.Ack(r.Offset)
+		return nil
+	})
+	if err := p.Wait(); err != nil {
+		return err
+	}
+	return cp.Save()
+}
+
+var errCrash = errors.New("crash")
+
+// checkResumed checks that out holds every input line at least once, and that the run
+// resumed at checkpoint wrote exactly the lines after it.
+func checkResumed(t *testing.T, out string, checkpoint int64, beforeResume int) {
+	t.Helper()
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	seen := make(map[string]int)
+	for _, l := range lines {
+		seen[l]++
+	}
+	for i := 0; i < ckptLines; i++ {
+		if want := fmt.Sprintf("LINE-%05d", i); seen[want] == 0 {
+			t.Fatalf("%s is missing from the output", want)
+		}
+	}
+	if checkpoint == 0 || checkpoint%int64(ckptLineLen) != 0 {
+		t.Fatalf("checkpoint %d is not a line boundary after the start", checkpoint)
+	}
+	if resumed, want := len(lines)-beforeResume, ckptLines-int(checkpoint)/ckptLineLen; resumed != want {
+		t.Errorf("resumed run wrote %d lines, want the %d after the checkpoint", resumed, want)
+	}
+	t.Logf("%d lines written before the crash, %d processed twice", beforeResume, len(lines)-ckptLines)
+}
+
+func countLines(path string) int {
+	data, _ := os.ReadFile(path)
+	return strings.Count(string(data), "\n")
+}
+
+func TestCheckpoint_ResumeAfterFailure(t *testing.T) {
+	dir := t.TempDir()
+	in, out, ckpt := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"), filepath.Join(dir, "ckpt")
+	writeCheckpointInput(t, in)
+
+	if err := runCheckpointed(context.Background(), in, out, ckpt, 200*time.Microsecond, 700); !errors.Is(err, errCrash) {
+		t.Fatalf("first run: got %v, want the crash", err)
+	}
+	store, _ := NewDirCheckpointStore(ckpt)
+	checkpoint, _ := store.Load("input")
+	beforeResume := countLines(out)
+
+	if err := runCheckpointed(context.Background(), in, out, ckpt, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkResumed(t, out, checkpoint, beforeResume)
+
+	// a finished pipeline resumes at the end and does nothing
+	if got, _ := store.Load("input"); got != int64(ckptLines*ckptLineLen) {
+		t.Errorf("final checkpoint %d, want the end of the input", got)
+	}
+	if err := runCheckpointed(context.Background(), in, out, ckpt, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := countLines(out); n != beforeResume+ckptLines-int(checkpoint)/ckptLineLen {
+		t.Errorf("rerunning a finished pipeline wrote %d more lines", n-beforeResume-ckptLines+int(checkpoint)/ckptLineLen)
+	}
+}
+
+// TestCheckpoint_Kill kills a process running the pipeline and resumes it from the
+// last checkpoint.
+func TestCheckpoint_Kill(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a subprocess")
+	}
+	dir := t.TempDir()
+	in, out, ckpt := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"), filepath.Join(dir, "ckpt")
+	writeCheckpointInput(t, in)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCheckpointHelper$")
+	cmd.Env = append(os.Environ(), "CHECKPOINT_HELPER="+strings.Join([]string{in, out, ckpt}, string(os.PathListSeparator)))
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	store, _ := NewDirCheckpointStore(ckpt)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if off, _ := store.Load("input"); off > 0 && countLines(out) >= 200 {
+			break
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			t.Fatal("the helper made no progress")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Wait()
+	beforeResume := countLines(out)
+	if beforeResume >= ckptLines {
+		t.Fatal("the helper finished before it was killed")
+	}
+	checkpoint, err := store.Load("input")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runCheckpointed(context.Background(), in, out, ckpt, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkResumed(t, out, checkpoint, beforeResume)
+}
+
+// TestCheckpointHelper runs the pipeline for TestCheckpoint_Kill in a subprocess.
+func TestCheckpointHelper(t *testing.T) {
+	args := os.Getenv("CHECKPOINT_HELPER")
+	if args == "" {
+		t.Skip("only run by TestCheckpoint_Kill")
+	}
+	paths := strings.Split(args, string(os.PathListSeparator))
+	// slow enough that the whole input takes several seconds
+	if err := runCheckpointed(context.Background(), paths[0], paths[1], paths[2], 10*time.Millisecond, 0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,462 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Errors reported when a Definition does not describe a valid pipeline. Compile wraps
+// them with the offending stage.
+var (
+	ErrUnknownStage = errors.New("unknown stage type")
+	ErrUnknownInput = errors.New("unknown input")
+	ErrTypeMismatch = errors.New("type mismatch")
+	ErrCycle        = errors.New("cycle")
+	ErrInvalidGraph = errors.New("invalid graph")
+)
+
+// Definition describes a pipeline as data, so it can be assembled from a JSON or YAML
+// file without recompiling:
+//
+//	name: ingest
+//	stages:
+//	  - {id: read, type: lines, params: {path: in.txt}}
+//	  - {id: parse, type: decode, inputs: [read], parallelism: 4, buffer: 64}
+//	  - {id: store, type: insert, inputs: [parse]}
+//
+// A stage with several inputs reads them merged; a stage read by several others feeds
+// each of them every item.
+type Definition struct {
+	Name   string            `json:"name" yaml:"name"`
+	Stages []StageDefinition `json:"stages" yaml:"stages"`
+}
+
+// StageDefinition is one stage of a Definition.
+type StageDefinition struct {
+	ID          string   `json:"id" yaml:"id"`
+	Type        string   `json:"type" yaml:"type"` // name in the Registry
+	Inputs      []string `json:"inputs" yaml:"inputs"`
+	Parallelism int      `json:"parallelism" yaml:"parallelism"` // copies run concurrently, default 1
+	Buffer      int      `json:"buffer" yaml:"buffer"`           // output buffer size, default unbuffered
+	Params      Params   `json:"params" yaml:"params"`
+}
+
+// ParseDefinition decodes a Definition from JSON or YAML.
+func ParseDefinition(r io.Reader) (Definition, error) {
+	var def Definition
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&def); err != nil {
+		return Definition{}, fmt.Errorf("parse pipeline definition: %w", err)
+	}
+	return def, nil
+}
+
+// Params holds the free-form parameters of a stage.
+type Params map[string]any
+
+// String returns the string parameter key, or def if it is not set.
+func (ps Params) String(key, def string) (string, error) {
+	v, ok := ps[key]
+	if !ok {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("param %s: want a string, got %v", key, v)
+	}
+	return s, nil
+}
+
+// Int returns the integer parameter key, or def if it is not set.
+func (ps Params) Int(key string, def int) (int, error) {
+	switch v := ps[key].(type) {
+	case nil:
+		return def, nil
+	case int:
+		return v, nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("param %s: want an integer, got %v", key, ps[key])
+}
+
+// Duration returns the duration parameter key, written like "250ms", or def if it is
+// not set.
+func (ps Params) Duration(key string, def time.Duration) (time.Duration, error) {
+	s, err := ps.String(key, "")
+	if err != nil || s == "" {
+		return def, err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("param %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// stageRole tells where a registered stage can appear in a graph.
+type stageRole int
+
+const (
+	roleSource stageRole = iota
+	roleStage
+	roleSink
+)
+
+func (r stageRole) String() string {
+	return [...]string{"source", "stage", "sink"}[r]
+}
+
+// entry is a registered stage type. Channels are passed around as any; the typed
+// helpers below are created by the generic Register functions, which know the element
+// types, so no conversion goroutines are needed.
+type entry struct {
+	role    stageRole
+	in, out reflect.Type
+	// build returns a function that starts one copy of the stage. in and the result are
+	// typed channels (<-chan In, <-chan Out) stored as any; sinks return nil.
+	build func(params Params) (func(p *Pipeline, in any) any, error)
+
+	serial bool // must not run as parallel copies
+
+	mergeIn   func(p *Pipeline, ins []any) any        // FanIn on <-chan In
+	mergeOut  func(p *Pipeline, outs []any) any       // FanIn on <-chan Out
+	bufferOut func(p *Pipeline, out any, n int) any   // Buffer on <-chan Out
+	teeOut    func(p *Pipeline, out any, n int) []any // Tee on <-chan Out
+}
+
+// Registry maps stage type names used in definitions to implementations.
+type Registry struct {
+	mu      sync.RWMutex
+	entries map[string]*entry
+}
+
+// NewRegistry returns an empty registry. DefaultRegistry returns one with the built-in
+// stage types already registered.
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]*entry)}
+}
+
+func (r *Registry) add(name string, e *entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.entries[name]; dup {
+		panic("pipeline: stage type " + name + " registered twice")
+	}
+	r.entries[name] = e
+}
+
+func (r *Registry) lookup(name string) (*entry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.entries[name]
+	return e, ok
+}
+
+func typeOf[T any]() reflect.Type { return reflect.TypeOf((*T)(nil)).Elem() }
+
+func typedChans[T any](chs []any) []<-chan T {
+	typed := make([]<-chan T, len(chs))
+	for i, ch := range chs {
+		typed[i] = ch.(<-chan T)
+	}
+	return typed
+}
+
+// outHelpers fills the helpers of e that work on its output type.
+func outHelpers[Out any](e *entry) {
+	e.out = typeOf[Out]()
+	e.mergeOut = func(p *Pipeline, outs []any) any { return FanIn(p, typedChans[Out](outs)...) }
+	e.bufferOut = func(p *Pipeline, out any, n int) any { return Buffer[Out](n)(p, out.(<-chan Out)) }
+	e.teeOut = func(p *Pipeline, out any, n int) []any {
+		outs := Tee(p, out.(<-chan Out), n)
+		return inputsOf(outs...)
+	}
+}
+
+// inHelpers fills the helpers of e that work on its input type.
+func inHelpers[In any](e *entry) {
+	e.in = typeOf[In]()
+	e.mergeIn = func(p *Pipeline, ins []any) any { return FanIn(p, typedChans[In](ins)...) }
+}
+
+// RegisterSource registers a stage type with no inputs that produces Out values.
+func RegisterSource[Out any](r *Registry, name string, build func(params Params) (func(p *Pipeline) <-chan Out, error)) {
+	e := &entry{role: roleSource}
+	outHelpers[Out](e)
+	e.build = func(params Params) (func(*Pipeline, any) any, error) {
+		src, err := build(params)
+		if err != nil {
+			return nil, err
+		}
+		return func(p *Pipeline, _ any) any { return src(p) }, nil
+	}
+	r.add(name, e)
+}
+
+// RegisterStage registers a stage type that turns In values into Out values.
+func RegisterStage[In, Out any](r *Registry, name string, build func(params Params) (Stage[In, Out], error)) {
+	e := &entry{role: roleStage}
+	inHelpers[In](e)
+	outHelpers[Out](e)
+	e.build = func(params Params) (func(*Pipeline, any) any, error) {
+		stage, err := build(params)
+		if err != nil {
+			return nil, err
+		}
+		return func(p *Pipeline, in any) any { return stage(p, in.(<-chan In)) }, nil
+	}
+	r.add(name, e)
+}
+
+// RegisterSink registers a stage type that consumes In values.
+func RegisterSink[In any](r *Registry, name string, build func(params Params) (func(ctx context.Context, v In) error, error)) {
+	e := &entry{role: roleSink}
+	inHelpers[In](e)
+	e.build = func(params Params) (func(*Pipeline, any) any, error) {
+		fn, err := build(params)
+		if err != nil {
+			return nil, err
+		}
+		return func(p *Pipeline, in any) any {
+			Sink(p, in.(<-chan In), fn)
+			return nil
+		}, nil
+	}
+	r.add(name, e)
+}
+
+// registerSinkStage registers a sink that needs the whole stream rather than one item at
+// a time, such as a file writer. It always runs as a single copy.
+func registerSinkStage[In any](r *Registry, name string, build func(params Params) (func(p *Pipeline, in <-chan In), error)) {
+	e := &entry{role: roleSink, serial: true}
+	inHelpers[In](e)
+	e.build = func(params Params) (func(*Pipeline, any) any, error) {
+		sink, err := build(params)
+		if err != nil {
+			return nil, err
+		}
+		return func(p *Pipeline, in any) any {
+			sink(p, in.(<-chan In))
+			return nil
+		}, nil
+	}
+	r.add(name, e)
+}
+
+// compiledStage is a validated stage ready to be started.
+type compiledStage struct {
+	def       StageDefinition
+	entry     *entry
+	start     func(p *Pipeline, in any) any
+	consumers int
+}
+
+// Plan is a validated, ready-to-run pipeline built from a Definition.
+type Plan struct {
+	name   string
+	stages []*compiledStage // in topological order
+}
+
+// Compile validates def against the registry and builds every stage's parameters. All
+// problems found are reported together, each wrapping one of the Err values above.
+func (r *Registry) Compile(def Definition) (*Plan, error) {
+	var errs []error
+	fail := func(id string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("stage %q: "+format, append([]any{id}, args...)...))
+	}
+
+	byID := make(map[string]*compiledStage, len(def.Stages))
+	var order []*compiledStage
+	for _, sd := range def.Stages {
+		switch {
+		case sd.ID == "":
+			fail(sd.ID, "%w: missing id", ErrInvalidGraph)
+			continue
+		case byID[sd.ID] != nil:
+			fail(sd.ID, "%w: duplicate id", ErrInvalidGraph)
+			continue
+		}
+		cs := &compiledStage{def: sd}
+		byID[sd.ID] = cs
+		order = append(order, cs)
+
+		e, ok := r.lookup(sd.Type)
+		if !ok {
+			fail(sd.ID, "%w %q", ErrUnknownStage, sd.Type)
+			continue
+		}
+		cs.entry = e
+		if sd.Parallelism < 0 || sd.Buffer < 0 {
+			fail(sd.ID, "%w: negative parallelism or buffer", ErrInvalidGraph)
+		}
+		if e.role == roleSource && sd.Parallelism > 1 {
+			fail(sd.ID, "%w: a source cannot run in parallel", ErrInvalidGraph)
+		}
+		if e.serial && sd.Parallelism > 1 {
+			fail(sd.ID, "%w: %s %s cannot run in parallel", ErrInvalidGraph, e.role, sd.Type)
+		}
+		if e.role == roleSink && sd.Buffer > 0 {
+			fail(sd.ID, "%w: a sink has no output to buffer", ErrInvalidGraph)
+		}
+		start, err := e.build(sd.Params)
+		if err != nil {
+			fail(sd.ID, "%w", err)
+		}
+		cs.start = start
+	}
+
+	// edges and types
+	for _, cs := range order {
+		if cs.entry == nil {
+			continue
+		}
+		switch {
+		case cs.entry.role == roleSource && len(cs.def.Inputs) > 0:
+			fail(cs.def.ID, "%w: source %s cannot have inputs", ErrInvalidGraph, cs.def.Type)
+		case cs.entry.role != roleSource && len(cs.def.Inputs) == 0:
+			fail(cs.def.ID, "%w: %s %s needs an input", ErrInvalidGraph, cs.entry.role, cs.def.Type)
+		}
+		for _, id := range cs.def.Inputs {
+			up, ok := byID[id]
+			switch {
+			case !ok:
+				fail(cs.def.ID, "%w %q", ErrUnknownInput, id)
+			case up.entry == nil:
+				// already reported as unknown
+			case up.entry.role == roleSink:
+				fail(cs.def.ID, "%w: input %q is a sink", ErrInvalidGraph, id)
+			case up.entry.out != cs.entry.in:
+				fail(cs.def.ID, "%w: input %q produces %v, %s accepts %v", ErrTypeMismatch, id, up.entry.out, cs.def.Type, cs.entry.in)
+			default:
+				up.consumers++
+			}
+		}
+	}
+	for _, cs := range order {
+		if cs.entry != nil && cs.entry.role != roleSink && cs.consumers == 0 {
+			fail(cs.def.ID, "%w: output is not consumed by any stage", ErrInvalidGraph)
+		}
+	}
+
+	sorted, cycle := topoSort(order, byID)
+	if cycle != nil {
+		errs = append(errs, fmt.Errorf("%w through stages %s", ErrCycle, strings.Join(cycle, ", ")))
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("pipeline %q: %w", def.Name, errors.Join(errs...))
+	}
+	return &Plan{name: def.Name, stages: sorted}, nil
+}
+
+// topoSort orders stages so that every stage comes after its inputs. If that is
+// impossible it returns the IDs of the stages left on cycles instead.
+func topoSort(order []*compiledStage, byID map[string]*compiledStage) ([]*compiledStage, []string) {
+	pending := make(map[string]int, len(order)) // inputs not yet placed
+	downstream := make(map[string][]*compiledStage)
+	var ready, sorted []*compiledStage
+	for _, cs := range order {
+		for _, id := range cs.def.Inputs {
+			if _, ok := byID[id]; ok {
+				pending[cs.def.ID]++
+				downstream[id] = append(downstream[id], cs)
+			}
+		}
+		if pending[cs.def.ID] == 0 {
+			ready = append(ready, cs)
+		}
+	}
+	for len(ready) > 0 {
+		cs := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, cs)
+		for _, down := range downstream[cs.def.ID] {
+			if pending[down.def.ID]--; pending[down.def.ID] == 0 {
+				ready = append(ready, down)
+			}
+		}
+	}
+	if len(sorted) == len(order) {
+		return sorted, nil
+	}
+	var cycle []string
+	for id, n := range pending {
+		if n > 0 {
+			cycle = append(cycle, id)
+		}
+	}
+	sort.Strings(cycle)
+	return nil, cycle
+}
+
+// Start builds the stages of the plan on p. Call p.Wait to run them to completion.
+func (pl *Plan) Start(p *Pipeline) {
+	outputs := make(map[string][]any) // unread copies of each stage's output
+	take := func(id string) any {
+		ch := outputs[id][0]
+		outputs[id] = outputs[id][1:]
+		return ch
+	}
+
+	for _, cs := range pl.stages {
+		e := cs.entry
+		p.graph.mu.Lock()
+		p.graph.label = cs.def.ID
+		p.graph.mu.Unlock()
+
+		var in any
+		if len(cs.def.Inputs) == 1 {
+			in = take(cs.def.Inputs[0])
+		} else if len(cs.def.Inputs) > 1 {
+			ins := make([]any, len(cs.def.Inputs))
+			for i, id := range cs.def.Inputs {
+				ins[i] = take(id)
+			}
+			in = e.mergeIn(p, ins)
+		}
+
+		copies := max(cs.def.Parallelism, 1)
+		outs := make([]any, copies)
+		for i := range outs {
+			outs[i] = cs.start(p, in)
+		}
+		if e.role == roleSink {
+			continue
+		}
+		out := outs[0]
+		if copies > 1 {
+			out = e.mergeOut(p, outs)
+		}
+		if cs.def.Buffer > 0 {
+			out = e.bufferOut(p, out, cs.def.Buffer)
+		}
+		if cs.consumers > 1 {
+			outputs[cs.def.ID] = e.teeOut(p, out, cs.consumers)
+		} else {
+			outputs[cs.def.ID] = []any{out}
+		}
+	}
+
+	p.graph.mu.Lock()
+	p.graph.label = ""
+	p.graph.mu.Unlock()
+}
+
+// Run starts the plan on a new pipeline and waits for it to finish.
+func (pl *Plan) Run(ctx context.Context) error {
+	p := New(ctx)
+	pl.Start(p)
+	return p.Wait()
+}
@@ -0,0 +1,315 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testRegistry registers a few stages over ints; collected receives what the sinks see.
+func testRegistry(collected *[]int, mu *sync.Mutex) *Registry {
+	r := NewRegistry()
+	RegisterSource(r, "numbers", func(ps Params) (func(p *Pipeline) <-chan int, error) {
+		count, err := ps.Int("count", 10)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]int, count)
+		for i := range items {
+			items[i] = i + 1
+		}
+		return func(p *Pipeline) <-chan int { return From(p, items...) }, nil
+	})
+	RegisterStage(r, "square", func(Params) (Stage[int, int], error) { return Map(square), nil })
+	RegisterStage(r, "even", func(Params) (Stage[int, int], error) {
+		return Filter(func(v int) bool { return v%2 == 0 }), nil
+	})
+	RegisterStage(r, "batch", func(ps Params) (Stage[int, []int], error) {
+		size, err := ps.Int("size", 10)
+		if err != nil {
+			return nil, err
+		}
+		timeout, err := ps.Duration("timeout", 0)
+		if err != nil {
+			return nil, err
+		}
+		return Batch[int](size, timeout), nil
+	})
+	RegisterStage(r, "format", func(Params) (Stage[int, string], error) {
+		return Map(func(_ context.Context, v int) (string, error) { return strconv.Itoa(v), nil }), nil
+	})
+	RegisterSink(r, "collect", func(Params) (func(context.Context, int) error, error) {
+		return func(_ context.Context, v int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			*collected = append(*collected, v)
+			return nil
+		}, nil
+	})
+	RegisterSink(r, "sum", func(Params) (func(context.Context, []int) error, error) {
+		return func(_ context.Context, batch []int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			total := 0
+			for _, v := range batch {
+				total += v
+			}
+			*collected = append(*collected, total)
+			return nil
+		}, nil
+	})
+	return r
+}
+
+func compile(t *testing.T, r *Registry, src string) (*Plan, error) {
+	t.Helper()
+	def, err := ParseDefinition(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.Compile(def)
+}
+
+func TestDefinition_Run(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []int
+	}{
+		{
+			name: "yaml",
+			src: `
+name: squares
+stages:
+  - id: src
+    type: numbers
+    params: {count: 5}
+  - id: sq
+    type: square
+    inputs: [src]
+    parallelism: 3
+    buffer: 4
+  - id: out
+    type: collect
+    inputs: [sq]
+`,
+			want: []int{1, 4, 9, 16, 25},
+		},
+		{
+			name: "json",
+			src: `{"name": "evens", "stages": [
+				{"id": "src", "type": "numbers", "params": {"count": 6}},
+				{"id": "even", "type": "even", "inputs": ["src"]},
+				{"id": "out", "type": "collect", "inputs": ["even"], "parallelism": 2}
+			]}`,
+			want: []int{2, 4, 6},
+		},
+		{
+			// src feeds both branches, which are merged again by out
+			name: "diamond",
+			src: `
+stages:
+  - {id: src, type: numbers, params: {count: 4}}
+  - {id: sq, type: square, inputs: [src]}
+  - {id: even, type: even, inputs: [src]}
+  - {id: out, type: collect, inputs: [sq, even]}
+`,
+			want: []int{1, 2, 4, 4, 9, 16},
+		},
+		{
+			name: "batch",
+			src: `
+stages:
+  - {id: src, type: numbers, params: {count: 10}}
+  - {id: batch, type: batch, inputs: [src], params: {size: 4, timeout: 1s}}
+  - {id: out, type: sum, inputs: [batch]}
+`,
+			want: []int{10, 19, 26},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			var (
+				mu  sync.Mutex
+				got []int
+			)
+			plan, err := compile(t, testRegistry(&got, &mu), tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := plan.Run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			checkNoLeak(t, before)
+		})
+	}
+}
+
+func TestDefinition_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want error
+	}{
+		{"unknown stage", `
+stages:
+  - {id: src, type: numbers}
+  - {id: out, type: store, inputs: [src]}`, ErrUnknownStage},
+		{"unknown input", `
+stages:
+  - {id: src, type: numbers}
+  - {id: out, type: collect, inputs: [source]}`, ErrUnknownInput},
+		{"type mismatch", `
+stages:
+  - {id: src, type: numbers}
+  - {id: fmt, type: format, inputs: [src]}
+  - {id: out, type: collect, inputs: [fmt]}`, ErrTypeMismatch},
+		{"cycle", `
+stages:
+  - {id: src, type: numbers}
+  - {id: a, type: square, inputs: [src, b]}
+  - {id: b, type: square, inputs: [a]}
+  - {id: out, type: collect, inputs: [b]}`, ErrCycle},
+		{"duplicate id", `
+stages:
+  - {id: src, type: numbers}
+  - {id: src, type: numbers}
+  - {id: out, type: collect, inputs: [src]}`, ErrInvalidGraph},
+		{"unconsumed output", `
+stages:
+  - {id: src, type: numbers}
+  - {id: sq, type: square, inputs: [src]}`, ErrInvalidGraph},
+		{"parallel source", `
+stages:
+  - {id: src, type: numbers, parallelism: 2}
+  - {id: out, type: collect, inputs: [src]}`, ErrInvalidGraph},
+		{"bad param", `
+stages:
+  - {id: src, type: numbers, params: {count: many}}
+  - {id: out, type: collect, inputs: [src]}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			_, err := compile(t, testRegistry(new([]int), &mu), tt.src)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestDefinition_ReportsAll tests that every problem is reported at once.
+func TestDefinition_ReportsAll(t *testing.T) {
+	var mu sync.Mutex
+	_, err := compile(t, testRegistry(new([]int), &mu), `
+stages:
+  - {id: src, type: numbers}
+  - {id: x, type: nope, inputs: [src]}
+  - {id: out, type: collect, inputs: [missing]}`)
+	if !errors.Is(err, ErrUnknownStage) || !errors.Is(err, ErrUnknownInput) {
+		t.Fatalf("got %v, want both the unknown stage and the unknown input", err)
+	}
+}
+
+// TestDefaultRegistry compiles and runs a file-to-file plan that uses only built-in
+// stage types.
+func TestDefaultRegistry(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.jsonl"), filepath.Join(dir, "out.jsonl")
+	lines := `{"id":1,"kind":"order","amount":5,"note":"x"}
+{"id":2,"kind":"refund","amount":3}
+{"id":3,"kind":"order","amount":7}
+{"id":4,"kind":"order","amount":1}
+`
+	if err := os.WriteFile(in, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := compile(t, DefaultRegistry(), `
+name: orders
+stages:
+  - {id: read, type: lines, params: {path: `+in+`}}
+  - {id: parse, type: decode, inputs: [read]}
+  - {id: orders, type: filter, inputs: [parse], params: {field: kind, equals: order}}
+  - {id: slim, type: map, inputs: [orders], params: {fields: "id, amount"}}
+  - {id: group, type: batch, inputs: [slim], params: {size: 2, timeout: 50ms}}
+  - {id: flat, type: unbatch, inputs: [group]}
+  - {id: write, type: write_jsonl, inputs: [flat], params: {path: `+out+`}}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"amount":5,"id":1}
+{"amount":7,"id":3}
+{"amount":1,"id":4}
+`
+	if string(got) != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// file sinks write one file, so they cannot be copied; missing paths are reported
+	_, err = compile(t, DefaultRegistry(), `
+stages:
+  - {id: read, type: lines}
+  - {id: write, type: write_lines, inputs: [read], parallelism: 2, params: {path: `+out+`}}
+`)
+	if !errors.Is(err, ErrInvalidGraph) || !strings.Contains(err.Error(), "param path: required") {
+		t.Fatalf("expected a parallel sink and a missing path to be reported, got %v", err)
+	}
+}
+
+func TestParseDefinition_UnknownField(t *testing.T) {
+	if _, err := ParseDefinition(strings.NewReader("stages:\n  - {id: a, type: numbers, paralellism: 2}\n")); err == nil {
+		t.Fatal("expected a misspelled field to be rejected")
+	}
+}
+
+// TestDefinition_Snapshot tests that nodes are labelled with their stage IDs.
+func TestDefinition_Snapshot(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got []int
+	)
+	plan, err := compile(t, testRegistry(&got, &mu), `
+stages:
+  - {id: src, type: numbers}
+  - {id: squares, type: square, inputs: [src]}
+  - {id: out, type: collect, inputs: [squares]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New(context.Background())
+	plan.Start(p)
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, st := range p.Snapshot().Stages {
+		names = append(names, st.Name)
+	}
+	if !slices.Contains(names, "squares/map") {
+		t.Errorf("stage names %v, want squares/map among them", names)
+	}
+}
@@ -0,0 +1,385 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Record is an item read by a file source with the byte offset just past it, so that
+// reading can resume after it with WithOffset. For gzip input the offset counts
+// uncompressed bytes.
+type Record[T any] struct {
+	Value  T
+	Offset int64
+}
+
+// Values returns a stage that drops the offsets of records.
+func Values[T any]() Stage[Record[T], T] {
+	return Map(func(_ context.Context, r Record[T]) (T, error) { return r.Value, nil })
+}
+
+// Input is something a source can read from: a File or a Stream.
+type Input interface {
+	open(offset int64) (io.ReadCloser, error)
+	String() string
+}
+
+// Output is something a sink can write to: a File or a StreamTo.
+type Output interface {
+	create(append bool) (io.WriteCloser, error)
+	gzipped() bool
+	String() string
+}
+
+// File is a path used as an Input or an Output. Gzip input is detected by its magic
+// bytes; output is compressed when the path ends in ".gz".
+type File string
+
+func (f File) String() string { return string(f) }
+
+func (f File) open(offset int64) (io.ReadCloser, error) {
+	file, err := os.Open(string(f))
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 2)
+	if n, _ := file.ReadAt(magic, 0); n == 2 && isGzip(magic) {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if err := skip(gz, offset); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return readCloser{gz, file.Close}, nil
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (f File) create(append bool) (io.WriteCloser, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if append {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	return os.OpenFile(string(f), flags, 0o644)
+}
+
+func (f File) gzipped() bool { return strings.HasSuffix(string(f), ".gz") }
+
+// Stream returns an Input reading from r, for example os.Stdin. Gzip is detected as for
+// files, and resuming at an offset discards that many bytes. A read blocked on r is only
+// interrupted by cancellation if r is also an io.Closer.
+func Stream(r io.Reader) Input { return stream{r} }
+
+type stream struct{ r io.Reader }
+
+func (s stream) String() string { return "stream" }
+
+func (s stream) open(offset int64) (io.ReadCloser, error) {
+	closer := func() error { return nil }
+	if c, ok := s.r.(io.Closer); ok {
+		closer = c.Close
+	}
+	br := bufio.NewReader(s.r)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); isGzip(magic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		r = gz
+	}
+	if err := skip(r, offset); err != nil {
+		return nil, err
+	}
+	return readCloser{r, closer}, nil
+}
+
+// StreamTo returns an Output writing to w, for example os.Stdout. w is not closed.
+func StreamTo(w io.Writer) Output { return streamTo{w} }
+
+type streamTo struct{ w io.Writer }
+
+func (s streamTo) String() string                      { return "stream" }
+func (s streamTo) create(bool) (io.WriteCloser, error) { return nopWriteCloser{s.w}, nil }
+func (s streamTo) gzipped() bool                       { return false }
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error { return r.close() }
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func isGzip(magic []byte) bool { return len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b }
+
+// skip discards the first offset bytes of r.
+func skip(r io.Reader, offset int64) error {
+	n, err := io.CopyN(io.Discard, r, offset)
+	if err == io.EOF {
+		return fmt.Errorf("offset %d is past the end of the input (%d bytes)", offset, n)
+	}
+	return err
+}
+
+// defaultChunkSize is the read buffer of file sources.
+const defaultChunkSize = 64 << 10
+
+// SourceOption configures a file source.
+type SourceOption func(*sourceConfig)
+
+type sourceConfig struct {
+	offset int64
+	chunk  int
+}
+
+// WithOffset resumes reading at offset, normally the Offset of the last record
+// processed. The offset must fall on a record boundary.
+func WithOffset(offset int64) SourceOption {
+	return func(c *sourceConfig) { c.offset = max(offset, 0) }
+}
+
+// WithChunkSize sets how many bytes a source reads from the input at a time.
+func WithChunkSize(n int) SourceOption {
+	return func(c *sourceConfig) {
+		if n > 0 {
+			c.chunk = n
+		}
+	}
+}
+
+// decoder reads the next value and returns the offset just past it, relative to where
+// reading started. It returns io.EOF at the end of the input.
+type decoder[T any] func() (v T, end int64, err error)
+
+// readSource runs a source of the given kind that decodes src with the decoder returned
+// by newDecoder. Cancelling the pipeline closes the input, which interrupts a blocked read.
+func readSource[T any](p *Pipeline, kind string, src Input, opts []SourceOption, newDecoder func(br *bufio.Reader) decoder[T]) <-chan Record[T] {
+	cfg := sourceConfig{chunk: defaultChunkSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	n := p.newNode(kind)
+	out := output(p, n, make(chan Record[T]))
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		rc, err := src.open(cfg.offset)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", kind, src, err)
+		}
+		defer rc.Close()
+		stop := context.AfterFunc(ctx, func() { rc.Close() })
+		defer stop()
+
+		next := newDecoder(bufio.NewReaderSize(rc, cfg.chunk))
+		offset := cfg.offset
+		for {
+			start := time.Now()
+			v, end, err := next()
+			n.observe(start)
+			switch {
+			case ctx.Err() != nil:
+				return nil
+			case err == io.EOF:
+				return nil
+			case err != nil:
+				return fmt.Errorf("%s %s: after offset %d: %w", kind, src, offset, err)
+			}
+			offset = cfg.offset + end
+			if !send(ctx, n, out, Record[T]{v, offset}) {
+				return nil
+			}
+		}
+	})
+	return out
+}
+
+// lineDecoder returns the lines of br without their line endings. A last line without a
+// newline is returned too.
+func lineDecoder(br *bufio.Reader) decoder[[]byte] {
+	var read int64
+	return func() ([]byte, int64, error) {
+		line, err := br.ReadBytes('\n')
+		read += int64(len(line))
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, read, err
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		return bytes.TrimSuffix(line, []byte("\r")), read, nil
+	}
+}
+
+// ReadLines returns a source of the lines of src, without line endings.
+func ReadLines(p *Pipeline, src Input, opts ...SourceOption) <-chan Record[string] {
+	return readSource(p, "readlines", src, opts, func(br *bufio.Reader) decoder[string] {
+		next := lineDecoder(br)
+		return func() (string, int64, error) {
+			line, end, err := next()
+			return string(line), end, err
+		}
+	})
+}
+
+// ReadCSV returns a source of the records of a CSV src. A header row is returned as an
+// ordinary record, and is not read again when resuming at an offset.
+func ReadCSV(p *Pipeline, src Input, opts ...SourceOption) <-chan Record[[]string] {
+	return readSource(p, "readcsv", src, opts, func(br *bufio.Reader) decoder[[]string] {
+		r := csv.NewReader(br)
+		r.FieldsPerRecord = -1
+		return func() ([]string, int64, error) {
+			rec, err := r.Read()
+			return rec, r.InputOffset(), err
+		}
+	})
+}
+
+// ReadJSONL returns a source that decodes each non-blank line of a JSON Lines src into a T.
+func ReadJSONL[T any](p *Pipeline, src Input, opts ...SourceOption) <-chan Record[T] {
+	return readSource(p, "readjsonl", src, opts, func(br *bufio.Reader) decoder[T] {
+		next := lineDecoder(br)
+		return func() (T, int64, error) {
+			var v T
+			for {
+				line, end, err := next()
+				if err != nil {
+					return v, end, err
+				}
+				if len(bytes.TrimSpace(line)) == 0 {
+					continue
+				}
+				return v, end, json.Unmarshal(line, &v)
+			}
+		}
+	})
+}
+
+// SinkOption configures a file sink.
+type SinkOption func(*sinkConfig)
+
+type sinkConfig struct {
+	append bool
+	gzip   bool
+}
+
+// Append makes a file sink add to an existing file instead of truncating it. Appending
+// to a gzip file adds a new gzip member, which readers handle transparently.
+func Append() SinkOption {
+	return func(c *sinkConfig) { c.append = true }
+}
+
+// WithGzip compresses the output even if it is not a File ending in ".gz".
+func WithGzip() SinkOption {
+	return func(c *sinkConfig) { c.gzip = true }
+}
+
+// encoder writes values to w and, on flush, anything it buffers itself.
+type encoder[T any] struct {
+	encode func(v T) error
+	flush  func() error
+}
+
+// writeSink runs a sink of the given kind that writes the items of in to dst. Output is
+// buffered and flushed, and dst closed, when in closes or the pipeline is cancelled.
+func writeSink[T any](p *Pipeline, kind string, in <-chan T, dst Output, opts []SinkOption, newEncoder func(w *bufio.Writer) encoder[T]) {
+	var cfg sinkConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	n := p.newNode(kind, in)
+	p.Go(func(ctx context.Context) (err error) {
+		wc, err := dst.create(cfg.append)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", kind, dst, err)
+		}
+		closers := []func() error{wc.Close}
+		var w io.Writer = wc
+		if cfg.gzip || dst.gzipped() {
+			gz := gzip.NewWriter(wc)
+			closers = append([]func() error{gz.Close}, closers...)
+			w = gz
+		}
+		bw := bufio.NewWriterSize(w, defaultChunkSize)
+		enc := newEncoder(bw)
+		closers = append([]func() error{enc.flush, bw.Flush}, closers...)
+		defer func() {
+			var errs []error
+			for _, c := range closers {
+				errs = append(errs, c())
+			}
+			if cerr := errors.Join(errs...); cerr != nil && err == nil {
+				err = fmt.Errorf("%s %s: %w", kind, dst, cerr)
+			}
+		}()
+
+		for {
+			v, ok := recv(ctx, n, in)
+			if !ok {
+				return nil
+			}
+			start := time.Now()
+			err := enc.encode(v)
+			n.observe(start)
+			if err != nil {
+				return fmt.Errorf("%s %s: %w", kind, dst, err)
+			}
+		}
+	})
+}
+
+// WriteLines writes every item of in to dst as a line.
+func WriteLines(p *Pipeline, in <-chan string, dst Output, opts ...SinkOption) {
+	writeSink(p, "writelines", in, dst, opts, func(w *bufio.Writer) encoder[string] {
+		return encoder[string]{
+			encode: func(s string) error {
+				w.WriteString(s)
+				return w.WriteByte('\n')
+			},
+			flush: func() error { return nil },
+		}
+	})
+}
+
+// WriteCSV writes every item of in to dst as a CSV record.
+func WriteCSV(p *Pipeline, in <-chan []string, dst Output, opts ...SinkOption) {
+	writeSink(p, "writecsv", in, dst, opts, func(w *bufio.Writer) encoder[[]string] {
+		cw := csv.NewWriter(w)
+		return encoder[[]string]{
+			encode: cw.Write,
+			flush: func() error {
+				cw.Flush()
+				return cw.Error()
+			},
+		}
+	})
+}
+
+// WriteJSONL writes every item of in to dst as a line of JSON.
+func WriteJSONL[T any](p *Pipeline, in <-chan T, dst Output, opts ...SinkOption) {
+	writeSink(p, "writejsonl", in, dst, opts, func(w *bufio.Writer) encoder[T] {
+		enc := json.NewEncoder(w)
+		return encoder[T]{
+			encode: func(v T) error { return enc.Encode(v) },
+			flush:  func() error { return nil },
+		}
+	})
+}
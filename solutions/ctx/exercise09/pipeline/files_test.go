@@ -0,0 +1,210 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// readAll runs the pipeline and returns every record of out.
+func readAll[T any](t *testing.T, p *Pipeline, out <-chan Record[T]) []Record[T] {
+	t.Helper()
+	var recs []Record[T]
+	Sink(p, out, func(_ context.Context, r Record[T]) error {
+		recs = append(recs, r)
+		return nil
+	})
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	return recs
+}
+
+func values[T any](recs []Record[T]) []T {
+	var vs []T
+	for _, r := range recs {
+		vs = append(vs, r.Value)
+	}
+	return vs
+}
+
+func TestLines_RoundTrip(t *testing.T) {
+	lines := []string{"alpha", "", "gamma", strings.Repeat("x", 300)}
+	for _, name := range []string{"lines.txt", "lines.txt.gz"} {
+		t.Run(name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			path := File(filepath.Join(t.TempDir(), name))
+
+			p := New(context.Background())
+			WriteLines(p, From(p, lines...), path)
+			if err := p.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			raw, _ := os.ReadFile(string(path))
+			if compressed := isGzip(raw[:2]); compressed != strings.HasSuffix(name, ".gz") {
+				t.Errorf("gzip = %v for %s", compressed, name)
+			}
+
+			// a small chunk size makes the long line span several reads
+			p = New(context.Background())
+			recs := readAll(t, p, ReadLines(p, path, WithChunkSize(16)))
+			if got := values(recs); !slices.Equal(got, lines) {
+				t.Fatalf("read %q, want %q", got, lines)
+			}
+
+			// resuming after each record yields exactly the records after it
+			for i, r := range recs {
+				p := New(context.Background())
+				rest := readAll(t, p, ReadLines(p, path, WithOffset(r.Offset)))
+				if got := values(rest); !slices.Equal(got, lines[i+1:]) {
+					t.Errorf("resume at %d: read %q, want %q", r.Offset, got, lines[i+1:])
+				}
+			}
+			checkNoLeak(t, before)
+		})
+	}
+}
+
+func TestReadLines_CRLFAndNoTrailingNewline(t *testing.T) {
+	p := New(context.Background())
+	recs := readAll(t, p, ReadLines(p, Stream(strings.NewReader("a\r\nb\nc"))))
+	if got := values(recs); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Fatalf("read %q", got)
+	}
+	if offsets := []int64{recs[0].Offset, recs[1].Offset, recs[2].Offset}; !slices.Equal(offsets, []int64{3, 5, 6}) {
+		t.Errorf("offsets %v, want [3 5 6]", offsets)
+	}
+}
+
+func TestCSV_RoundTripAndResume(t *testing.T) {
+	rows := [][]string{{"id", "note"}, {"1", "plain"}, {"2", "two\nlines, quoted"}, {"3", ""}}
+	path := File(filepath.Join(t.TempDir(), "rows.csv.gz"))
+	p := New(context.Background())
+	WriteCSV(p, From(p, rows...), path)
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	p = New(context.Background())
+	recs := readAll(t, p, ReadCSV(p, path))
+	if got := values(recs); !slices.EqualFunc(got, rows, slices.Equal) {
+		t.Fatalf("read %q, want %q", got, rows)
+	}
+	p = New(context.Background())
+	rest := readAll(t, p, ReadCSV(p, path, WithOffset(recs[1].Offset)))
+	if got := values(rest); !slices.EqualFunc(got, rows[2:], slices.Equal) {
+		t.Errorf("resume: read %q, want %q", got, rows[2:])
+	}
+}
+
+type order struct {
+	ID    int    `json:"id"`
+	Item  string `json:"item"`
+	Count int    `json:"count"`
+}
+
+func TestJSONL(t *testing.T) {
+	orders := []order{{1, "tea", 2}, {2, "cake", 1}}
+	var buf bytes.Buffer
+	p := New(context.Background())
+	WriteJSONL(p, From(p, orders...), StreamTo(&buf))
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// blank lines are skipped
+	src := strings.ReplaceAll(buf.String(), "\n", "\n\n")
+	p = New(context.Background())
+	if got := values(readAll(t, p, ReadJSONL[order](p, Stream(strings.NewReader(src))))); !slices.Equal(got, orders) {
+		t.Fatalf("read %v, want %v", got, orders)
+	}
+
+	p = New(context.Background())
+	Sink(p, ReadJSONL[order](p, Stream(strings.NewReader(buf.String()+"{oops\n"))), func(context.Context, Record[order]) error { return nil })
+	err := p.Wait()
+	if want := fmt.Sprintf("after offset %d", buf.Len()); err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got %v, want a decode error %s", err, want)
+	}
+}
+
+func TestReadSource_Errors(t *testing.T) {
+	p := New(context.Background())
+	Sink(p, ReadLines(p, File(filepath.Join(t.TempDir(), "missing"))), func(context.Context, Record[string]) error { return nil })
+	if err := p.Wait(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got %v", err)
+	}
+
+	p = New(context.Background())
+	Sink(p, ReadLines(p, Stream(strings.NewReader("short\n")), WithOffset(100)), func(context.Context, Record[string]) error { return nil })
+	if err := p.Wait(); err == nil {
+		t.Error("expected an error for an offset past the end")
+	}
+}
+
+// TestReadLines_CancelBlockedRead tests that cancelling interrupts a read waiting on a stream.
+func TestReadLines_CancelBlockedRead(t *testing.T) {
+	before := runtime.NumGoroutine()
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	p := New(context.Background())
+	out := ReadLines(p, Stream(pr))
+	go pw.Write([]byte("first\n"))
+	if r := <-out; r.Value != "first" {
+		t.Fatalf("got %q", r.Value)
+	}
+	p.Stop()
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	checkNoLeak(t, before)
+}
+
+// TestWriteLines_FlushOnCancel tests that items already received are written when the
+// pipeline is cancelled before the input closes.
+func TestWriteLines_FlushOnCancel(t *testing.T) {
+	path := File(filepath.Join(t.TempDir(), "out.txt.gz"))
+	sent := make(chan struct{})
+	p := New(context.Background())
+	in := Generate(p, func(ctx context.Context, emit func(string) bool) error {
+		for _, s := range []string{"a", "b", "c"} {
+			emit(s)
+		}
+		close(sent)
+		<-ctx.Done()
+		return nil
+	})
+	WriteLines(p, in, path)
+	<-sent
+	p.Stop()
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	p = New(context.Background())
+	if got := values(readAll(t, p, ReadLines(p, path))); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Fatalf("read %q after cancel, want [a b c]", got)
+	}
+}
+
+func TestWriteLines_Append(t *testing.T) {
+	path := File(filepath.Join(t.TempDir(), "log.gz"))
+	for _, batch := range [][]string{{"1", "2"}, {"3"}} {
+		p := New(context.Background())
+		WriteLines(p, From(p, batch...), path, Append())
+		if err := p.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := New(context.Background())
+	if got := values(readAll(t, p, ReadLines(p, path))); !slices.Equal(got, []string{"1", "2", "3"}) {
+		t.Fatalf("read %q, want [1 2 3]", got)
+	}
+}
@@ -0,0 +1,415 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// node is one stage in the pipeline graph and the metrics it records. Every helper in
+// this package that starts goroutines registers a node, and links it to the nodes that
+// produced its input channels.
+type node struct {
+	id       int
+	kind     string
+	name     string
+	inputs   []int
+	received []*atomic.Int64 // items received over each of inputs
+	inChans  []any           // the channel behind each of inputs
+
+	// guarded by graph.mu
+	upstream []edge // the channels n reads that another node produces
+	outputs  int    // channels n produces
+	detached int    // outputs whose readers have all detached
+	gone     chan struct{}
+
+	in, out     atomic.Int64
+	recvBlocked atomic.Int64 // nanoseconds spent waiting for input
+	sendBlocked atomic.Int64 // nanoseconds spent waiting for downstream to take output
+	busy        atomic.Int64 // nanoseconds spent in user callbacks
+	calls       atomic.Int64 // user callback invocations measured in busy
+
+	mu      sync.Mutex
+	buffers []func() (length, capacity int) // output channels, sampled by Snapshot
+}
+
+// edge is a channel from the node that produces it to a node that reads it.
+type edge struct {
+	from *node
+	ch   any
+}
+
+// graph holds the nodes of a pipeline and which node produced each channel.
+type graph struct {
+	mu        sync.Mutex
+	nodes     []*node
+	producers map[any]*node // keyed by the channel as a receive-only channel
+	readers   map[any]int   // number of nodes still reading each produced channel
+	label     string        // set by Named while its stage is being built
+}
+
+// newNode registers a stage of the given kind reading from inputs, which must be
+// receive-only channels.
+func (p *Pipeline) newNode(kind string, inputs ...any) *node {
+	g := &p.graph
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := &node{id: len(g.nodes), kind: kind, name: kind, gone: make(chan struct{})}
+	if g.label != "" {
+		n.name = g.label + "/" + kind
+	}
+	for _, in := range inputs {
+		if up, ok := g.producers[in]; ok {
+			n.inputs = append(n.inputs, up.id)
+			n.received = append(n.received, new(atomic.Int64))
+			n.inChans = append(n.inChans, in)
+			n.upstream = append(n.upstream, edge{up, in})
+			g.readers[in]++
+		}
+	}
+	g.nodes = append(g.nodes, n)
+	return n
+}
+
+// output records that n produces ch, so that stages reading ch link back to n and
+// Snapshot can sample ch's buffer.
+func output[T any](p *Pipeline, n *node, ch chan T) chan T {
+	g := &p.graph
+	g.mu.Lock()
+	if g.producers == nil {
+		g.producers = make(map[any]*node)
+		g.readers = make(map[any]int)
+	}
+	g.producers[(<-chan T)(ch)] = n
+	n.outputs++
+	g.mu.Unlock()
+
+	n.mu.Lock()
+	n.buffers = append(n.buffers, func() (int, int) { return len(ch), cap(ch) })
+	n.mu.Unlock()
+	return ch
+}
+
+// detach records that n has stopped reading its inputs for good. A node whose outputs
+// are all read only by detached nodes is stopped: its sends and receives fail so its
+// goroutines wind down, and it detaches from its own inputs in turn. Outputs read
+// outside the pipeline's stages are never considered detached. detach reports whether
+// every input of n was produced by a node that is now stopped.
+func (p *Pipeline) detach(n *node) bool {
+	g := &p.graph
+	g.mu.Lock()
+	var stopped []*node
+	all := len(n.upstream) > 0
+	for _, e := range n.upstream {
+		g.readers[e.ch]--
+		if g.readers[e.ch] == 0 {
+			e.from.detached++
+			if e.from.detached == e.from.outputs {
+				stopped = append(stopped, e.from)
+				continue
+			}
+		}
+		all = false
+	}
+	n.upstream = nil
+	g.mu.Unlock()
+
+	for _, up := range stopped {
+		close(up.gone)
+		p.detach(up)
+	}
+	return all
+}
+
+// readByStages reports whether any stage of p reads ch, a receive-only channel.
+func (p *Pipeline) readByStages(ch any) bool {
+	g := &p.graph
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.readers[ch] > 0
+}
+
+// inputsOf converts channels to the form newNode expects.
+func inputsOf[T any](chs ...<-chan T) []any {
+	inputs := make([]any, len(chs))
+	for i, ch := range chs {
+		inputs[i] = ch
+	}
+	return inputs
+}
+
+// recv receives from in on behalf of n. It reports false once in is closed, ctx is done
+// or n has been stopped because nothing reads its output any more.
+func recv[T any](ctx context.Context, n *node, in <-chan T) (T, bool) {
+	select {
+	case v, ok := <-in:
+		if ok {
+			n.receivedFrom(in)
+		}
+		return v, ok
+	default:
+	}
+	start := time.Now()
+	defer func() { n.recvBlocked.Add(int64(time.Since(start))) }()
+	select {
+	case <-ctx.Done():
+		var zero T
+		return zero, false
+	case <-n.gone:
+		var zero T
+		return zero, false
+	case v, ok := <-in:
+		if ok {
+			n.receivedFrom(in)
+		}
+		return v, ok
+	}
+}
+
+// receivedFrom counts one item n received on in, both in total and on the edge from
+// the stage that produced in.
+func (n *node) receivedFrom(in any) {
+	n.in.Add(1)
+	for i, ch := range n.inChans {
+		if ch == in {
+			n.received[i].Add(1)
+			return
+		}
+	}
+}
+
+// send delivers v on out on behalf of n unless ctx is done or n is stopped first.
+func send[T any](ctx context.Context, n *node, out chan<- T, v T) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	select {
+	case <-n.gone:
+		return false
+	default:
+	}
+	select {
+	case out <- v:
+		n.out.Add(1)
+		return true
+	default:
+	}
+	start := time.Now()
+	defer func() { n.sendBlocked.Add(int64(time.Since(start))) }()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-n.gone:
+		return false
+	case out <- v:
+	}
+	n.out.Add(1)
+	return true
+}
+
+// context returns a context derived from ctx that is also cancelled once n is stopped,
+// for stages whose goroutines wait on each other and not only through send and recv.
+func (n *node) context(ctx context.Context) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		select {
+		case <-n.gone:
+		case <-ctx.Done():
+		}
+	}()
+	return ctx
+}
+
+// waited adds the time since start to n's input wait, for stages that wait on input and
+// timers in one select.
+func (n *node) waited(start time.Time) {
+	n.recvBlocked.Add(int64(time.Since(start)))
+}
+
+// observe records one user callback that started at start.
+func (n *node) observe(start time.Time) {
+	n.busy.Add(int64(time.Since(start)))
+	n.calls.Add(1)
+}
+
+// Named returns stage with its nodes labelled name in snapshots.
+func Named[In, Out any](name string, stage Stage[In, Out]) Stage[In, Out] {
+	return func(p *Pipeline, in <-chan In) <-chan Out {
+		g := &p.graph
+		g.mu.Lock()
+		outer := g.label
+		g.label = name
+		g.mu.Unlock()
+		defer func() {
+			g.mu.Lock()
+			g.label = outer
+			g.mu.Unlock()
+		}()
+		return stage(p, in)
+	}
+}
+
+// Buffer returns a stage that passes items through a channel with room for n items,
+// decoupling a bursty producer from its consumer. Its occupancy shows up in snapshots.
+func Buffer[T any](n int) Stage[T, T] {
+	return func(p *Pipeline, in <-chan T) <-chan T {
+		nd := p.newNode("buffer", in)
+		out := output(p, nd, make(chan T, n))
+		p.Go(func(ctx context.Context) error {
+			defer close(out)
+			for {
+				v, ok := recv(ctx, nd, in)
+				if !ok || !send(ctx, nd, out, v) {
+					return nil
+				}
+			}
+		})
+		return out
+	}
+}
+
+// StageStats are the metrics of one stage at the time of a snapshot.
+type StageStats struct {
+	ID     int
+	Name   string
+	Inputs []int // IDs of the stages feeding this one
+
+	// Received[i] is the number of items received from Inputs[i]. A stage with several
+	// outputs, such as Tee or Partition, sends different counts along each edge.
+	Received []int64
+
+	In, Out     int64
+	RecvBlocked time.Duration // total time waiting for input
+	SendBlocked time.Duration // total time waiting for downstream: backpressure
+	Busy        time.Duration // total time in user callbacks
+	Latency     time.Duration // mean time per user callback
+	BufferLen   int           // items waiting in the stage's output channels
+	BufferCap   int
+}
+
+// Snapshot is a point-in-time view of every stage of a pipeline.
+type Snapshot struct {
+	Stages []StageStats
+}
+
+// Snapshot returns the current metrics of every stage, in creation order.
+func (p *Pipeline) Snapshot() Snapshot {
+	p.graph.mu.Lock()
+	nodes := append([]*node(nil), p.graph.nodes...)
+	p.graph.mu.Unlock()
+
+	snap := Snapshot{Stages: make([]StageStats, len(nodes))}
+	for i, n := range nodes {
+		s := StageStats{
+			ID:          n.id,
+			Name:        n.name,
+			Inputs:      n.inputs,
+			Received:    make([]int64, len(n.received)),
+			In:          n.in.Load(),
+			Out:         n.out.Load(),
+			RecvBlocked: time.Duration(n.recvBlocked.Load()),
+			SendBlocked: time.Duration(n.sendBlocked.Load()),
+			Busy:        time.Duration(n.busy.Load()),
+		}
+		for j, c := range n.received {
+			s.Received[j] = c.Load()
+		}
+		if calls := n.calls.Load(); calls > 0 {
+			s.Latency = s.Busy / time.Duration(calls)
+		}
+		n.mu.Lock()
+		for _, buffer := range n.buffers {
+			length, capacity := buffer()
+			s.BufferLen += length
+			s.BufferCap += capacity
+		}
+		n.mu.Unlock()
+		snap.Stages[i] = s
+	}
+	return snap
+}
+
+// Bottleneck returns the stage that spent the most time working rather than waiting,
+// measured as busy time, or -1 if no stage has run a callback yet.
+func (s Snapshot) Bottleneck() int {
+	best := -1
+	for i, st := range s.Stages {
+		if st.Busy > 0 && (best < 0 || st.Busy > s.Stages[best].Busy) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return -1
+	}
+	return s.Stages[best].ID
+}
+
+// WriteText writes the snapshot as a table, one stage per line.
+func (s Snapshot) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "id\tstage\tfrom\tin\tout\trecv blocked\tsend blocked\tlatency\tbuffer")
+	for _, st := range s.Stages {
+		from := make([]string, len(st.Inputs))
+		for i, id := range st.Inputs {
+			from[i] = fmt.Sprint(id)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t%v\t%v\t%v\t%d/%d\n", st.ID, st.Name, strings.Join(from, ","),
+			st.In, st.Out, st.RecvBlocked.Round(time.Microsecond), st.SendBlocked.Round(time.Microsecond),
+			st.Latency.Round(time.Microsecond), st.BufferLen, st.BufferCap)
+	}
+	return tw.Flush()
+}
+
+// WriteDOT writes the snapshot as a Graphviz graph. Each edge is labelled with the items
+// that crossed it, and the bottleneck stage is highlighted.
+func (s Snapshot) WriteDOT(w io.Writer) error {
+	bottleneck := s.Bottleneck()
+
+	var b strings.Builder
+	b.WriteString("digraph pipeline {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, st := range s.Stages {
+		attrs := ""
+		if st.ID == bottleneck {
+			attrs = ", style=filled, fillcolor=salmon"
+		}
+		fmt.Fprintf(&b, "\tn%d [label=%q%s];\n", st.ID, fmt.Sprintf("%s\nin %d out %d\nlatency %v\nblocked recv %v send %v\nbuffer %d/%d",
+			st.Name, st.In, st.Out, st.Latency.Round(time.Microsecond), st.RecvBlocked.Round(time.Millisecond),
+			st.SendBlocked.Round(time.Millisecond), st.BufferLen, st.BufferCap), attrs)
+	}
+	for _, st := range s.Stages {
+		order := make([]int, len(st.Inputs))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool { return st.Inputs[order[a]] < st.Inputs[order[b]] })
+		for _, i := range order {
+			fmt.Fprintf(&b, "\tn%d -> n%d [label=\"%d\"];\n", st.Inputs[i], st.ID, st.Received[i])
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// MetricsHandler serves snapshots of p: a text table by default, or a Graphviz graph
+// with ?format=dot.
+func MetricsHandler(p *Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snap := p.Snapshot()
+		if r.URL.Query().Get("format") == "dot" {
+			w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+			snap.WriteDOT(w)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		snap.WriteText(w)
+	})
+}
@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stageNamed returns the stats of the first stage called name.
+func stageNamed(t *testing.T, snap Snapshot, name string) StageStats {
+	t.Helper()
+	for _, st := range snap.Stages {
+		if st.Name == name {
+			return st
+		}
+	}
+	t.Fatalf("no stage %q in %+v", name, snap.Stages)
+	return StageStats{}
+}
+
+func TestSnapshot(t *testing.T) {
+	p := New(context.Background())
+	items := make([]int, 20)
+	src := From(p, items...)
+	squared := Named("square", Parallel(2, Map(square)))(p, src)
+	buffered := Buffer[int](4)(p, squared)
+	// the sink is the slow stage, so everything upstream ends up blocked on sending
+	Sink(p, buffered, func(context.Context, int) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := p.Snapshot()
+	gen, fanin, buf, sink := stageNamed(t, snap, "generate"), stageNamed(t, snap, "square/fanin"),
+		stageNamed(t, snap, "buffer"), stageNamed(t, snap, "sink")
+
+	var maps []StageStats
+	for _, st := range snap.Stages {
+		if st.Name == "square/map" {
+			maps = append(maps, st)
+		}
+	}
+	if len(maps) != 2 {
+		t.Fatalf("got %d square/map stages, want 2", len(maps))
+	}
+	var mapped int64
+	for _, m := range maps {
+		if len(m.Inputs) != 1 || m.Inputs[0] != gen.ID {
+			t.Errorf("map inputs = %v, want [%d]", m.Inputs, gen.ID)
+		}
+		mapped += m.In
+	}
+	if len(fanin.Inputs) != 2 || buf.Inputs[0] != fanin.ID || sink.Inputs[0] != buf.ID {
+		t.Errorf("unexpected edges: fanin %v, buffer %v, sink %v", fanin.Inputs, buf.Inputs, sink.Inputs)
+	}
+
+	if gen.Out != 20 || mapped != 20 || fanin.In != 20 || fanin.Out != 20 || buf.Out != 20 || sink.In != 20 {
+		t.Errorf("item counts: generate out %d, maps in %d, fanin %d/%d, buffer out %d, sink in %d, want 20 each",
+			gen.Out, mapped, fanin.In, fanin.Out, buf.Out, sink.In)
+	}
+	if buf.BufferCap != 4 {
+		t.Errorf("buffer capacity = %d, want 4", buf.BufferCap)
+	}
+	if sink.Latency < time.Millisecond {
+		t.Errorf("sink latency = %v, want at least the 1ms it sleeps", sink.Latency)
+	}
+	if buf.SendBlocked == 0 {
+		t.Error("expected the buffer stage to be blocked sending to the slow sink")
+	}
+	if snap.Bottleneck() != sink.ID {
+		t.Errorf("bottleneck = %d, want the sink %d", snap.Bottleneck(), sink.ID)
+	}
+}
+
+func TestSnapshot_BufferOccupancy(t *testing.T) {
+	p := New(context.Background())
+	out := Buffer[int](3)(p, naturals(p))
+	<-out
+	deadline := time.Now().Add(time.Second)
+	for stageNamed(t, p.Snapshot(), "buffer").BufferLen != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("buffer never filled: %+v", stageNamed(t, p.Snapshot(), "buffer"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	p.Stop()
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	p := New(context.Background())
+	Sink(p, Filter(isEven)(p, From(p, 1, 2, 3, 4)), func(context.Context, int) error { return nil })
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	h := MetricsHandler(p)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pipeline", nil))
+	text := rec.Body.String()
+	for _, want := range []string{"generate", "filter", "sink", "send blocked"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text output missing %q:\n%s", want, text)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pipeline?format=dot", nil))
+	dot := rec.Body.String()
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/vnd.graphviz") {
+		t.Errorf("content type = %q, want graphviz", ct)
+	}
+	// generate -> filter carries 4 items, filter -> sink the 2 even ones
+	for _, want := range []string{"digraph pipeline", `n0 -> n1 [label="4"]`, `n1 -> n2 [label="2"]`} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT output missing %q:\n%s", want, dot)
+		}
+	}
+}
+
+// TestWriteDOT_EdgeCounts tests that each edge is labelled with the items that crossed
+// it rather than the producer's total output, which a Tee doubles.
+func TestWriteDOT_EdgeCounts(t *testing.T) {
+	p := New(context.Background())
+	outs := Tee(p, From(p, 1, 2, 3, 4, 5), 2)
+	Sink(p, outs[0], func(context.Context, int) error { return nil })
+	Sink(p, Filter(isEven)(p, outs[1]), func(context.Context, int) error { return nil })
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := p.Snapshot()
+	if tee := snap.Stages[1]; tee.Name != "tee" || tee.Out != 10 {
+		t.Fatalf("expected stage 1 to be the tee with 10 items out, got %+v", tee)
+	}
+	var b strings.Builder
+	if err := snap.WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	// tee (n1) sends 5 items down each edge; the filter (n3) passes 2 to its sink (n4)
+	for _, want := range []string{`n0 -> n1 [label="5"]`, `n1 -> n2 [label="5"]`, `n1 -> n3 [label="5"]`, `n3 -> n4 [label="2"]`} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("DOT output missing %q:\n%s", want, b.String())
+		}
+	}
+}
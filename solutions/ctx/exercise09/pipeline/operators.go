@@ -0,0 +1,452 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"exercise05"
+)
+
+// transform runs fn for every item of in on a goroutine owned by p, as a stage of the
+// given kind, and closes the output afterwards. fn sends with the emit function it is
+// given and returns false to stop early; done, if not nil, runs once the input is closed
+// and may emit a final item.
+func transform[In, Out any](p *Pipeline, kind string, in <-chan In, fn func(ctx context.Context, v In, emit func(Out) bool) bool, done func(emit func(Out) bool)) <-chan Out {
+	n := p.newNode(kind, in)
+	out := output(p, n, make(chan Out))
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		emit := func(v Out) bool { return send(ctx, n, out, v) }
+		for {
+			v, ok := recv(ctx, n, in)
+			if !ok {
+				if done != nil && ctx.Err() == nil {
+					done(emit)
+				}
+				return nil
+			}
+			// fn emits inline, so time blocked on emitting is not counted as work
+			start, blocked := time.Now(), n.sendBlocked.Load()
+			cont := fn(ctx, v, emit)
+			n.busy.Add(int64(time.Since(start)) - (n.sendBlocked.Load() - blocked))
+			n.calls.Add(1)
+			if !cont {
+				return nil
+			}
+		}
+	})
+	return out
+}
+
+// Filter returns a stage that passes on only the items for which keep returns true.
+func Filter[T any](keep func(T) bool) Stage[T, T] {
+	return func(p *Pipeline, in <-chan T) <-chan T {
+		return transform(p, "filter", in, func(_ context.Context, v T, emit func(T) bool) bool {
+			return !keep(v) || emit(v)
+		}, nil)
+	}
+}
+
+// Scan returns a stage that emits the running accumulation of fn over the input,
+// starting from init.
+func Scan[T, A any](init A, fn func(acc A, v T) A) Stage[T, A] {
+	return func(p *Pipeline, in <-chan T) <-chan A {
+		acc := init
+		return transform(p, "scan", in, func(_ context.Context, v T, emit func(A) bool) bool {
+			acc = fn(acc, v)
+			return emit(acc)
+		}, nil)
+	}
+}
+
+// Reduce returns a stage that folds the whole input with fn, starting from init, and
+// emits the result once the input closes. Nothing is emitted if the pipeline is
+// cancelled first.
+func Reduce[T, A any](init A, fn func(acc A, v T) A) Stage[T, A] {
+	return func(p *Pipeline, in <-chan T) <-chan A {
+		acc := init
+		return transform(p, "reduce", in, func(_ context.Context, v T, _ func(A) bool) bool {
+			acc = fn(acc, v)
+			return true
+		}, func(emit func(A) bool) { emit(acc) })
+	}
+}
+
+// Take returns a stage that emits the first n items and then closes its output. The
+// stages feeding it are then stopped, so an endless source does not keep the pipeline
+// running. If its input is also read by other stages or comes from outside the pipeline,
+// Take keeps reading and discarding the rest instead, so that the producer is not held up.
+func Take[T any](n int) Stage[T, T] {
+	return func(p *Pipeline, in <-chan T) <-chan T {
+		nd := p.newNode("take", in)
+		out := output(p, nd, make(chan T))
+		p.Go(func(ctx context.Context) error {
+			for taken := 0; taken < n; taken++ {
+				v, ok := recv(ctx, nd, in)
+				if !ok || !send(ctx, nd, out, v) {
+					close(out)
+					return nil
+				}
+			}
+			close(out)
+			if p.detach(nd) {
+				// the stages feeding in have nobody else to serve and wind down
+				return nil
+			}
+			// in is shared with other readers or fed from outside the pipeline: keep
+			// draining so its producer is not held up
+			for {
+				if _, ok := recv(ctx, nd, in); !ok {
+					return nil
+				}
+			}
+		})
+		return out
+	}
+}
+
+// Skip returns a stage that drops the first n items and passes on the rest.
+func Skip[T any](n int) Stage[T, T] {
+	return func(p *Pipeline, in <-chan T) <-chan T {
+		skipped := 0
+		return transform(p, "skip", in, func(_ context.Context, v T, emit func(T) bool) bool {
+			if skipped < n {
+				skipped++
+				return true
+			}
+			return emit(v)
+		}, nil)
+	}
+}
+
+// Distinct returns a stage that passes on only the first item for each key. It remembers
+// every key it has seen, so the key space should be bounded.
+func Distinct[T any, K comparable](key func(T) K) Stage[T, T] {
+	return func(p *Pipeline, in <-chan T) <-chan T {
+		seen := make(map[K]struct{})
+		return transform(p, "distinct", in, func(_ context.Context, v T, emit func(T) bool) bool {
+			k := key(v)
+			if _, dup := seen[k]; dup {
+				return true
+			}
+			seen[k] = struct{}{}
+			return emit(v)
+		}, nil)
+	}
+}
+
+// Debounce returns a stage that emits an item only once d has passed without a newer
+// one; intermediate items are dropped. The last pending item is emitted when the input
+// closes.
+func Debounce[T any](d time.Duration) Stage[T, T] {
+	return func(p *Pipeline, in <-chan T) <-chan T {
+		n := p.newNode("debounce", in)
+		out := output(p, n, make(chan T))
+		p.Go(func(ctx context.Context) error {
+			defer close(out)
+			var (
+				pending T
+				timer   *time.Timer
+				fire    <-chan time.Time
+			)
+			defer func() {
+				if timer != nil {
+					timer.Stop()
+				}
+			}()
+			for {
+				waiting := time.Now()
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-n.gone:
+					return nil
+				case <-fire:
+					n.waited(waiting)
+					fire = nil
+					if !send(ctx, n, out, pending) {
+						return nil
+					}
+				case v, ok := <-in:
+					n.waited(waiting)
+					if !ok {
+						if fire != nil {
+							send(ctx, n, out, pending)
+						}
+						return nil
+					}
+					n.receivedFrom(in)
+					pending = v
+					if timer == nil {
+						timer = time.NewTimer(d)
+					} else {
+						timer.Reset(d)
+					}
+					fire = timer.C
+				}
+			}
+		})
+		return out
+	}
+}
+
+// Throttle returns a stage that waits for limiter before passing on each item, for
+// example an exercise05.TokenBucket to cap a stream at a number of items per second.
+func Throttle[T any](limiter exercise05.Limiter) Stage[T, T] {
+	return func(p *Pipeline, in <-chan T) <-chan T {
+		return transform(p, "throttle", in, func(ctx context.Context, v T, emit func(T) bool) bool {
+			return limiter.Wait(ctx) == nil && emit(v)
+		}, nil)
+	}
+}
+
+// Tee copies every item of in to n outputs. Each item is delivered to all outputs before
+// the next one is read, so the slowest reader sets the pace; use Broadcast when readers
+// must not hold each other up.
+func Tee[T any](p *Pipeline, in <-chan T, n int) []<-chan T {
+	nd := p.newNode("tee", in)
+	outs := make([]chan T, n)
+	result := make([]<-chan T, n)
+	for i := range outs {
+		outs[i] = output(p, nd, make(chan T))
+		result[i] = outs[i]
+	}
+	p.Go(func(ctx context.Context) error {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+		for {
+			v, ok := recv(ctx, nd, in)
+			if !ok {
+				return nil
+			}
+			for _, out := range outs {
+				if !send(ctx, nd, out, v) {
+					return nil
+				}
+			}
+		}
+	})
+	return result
+}
+
+// Pair holds one item from each input of Zip.
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// Zip pairs the i-th items of a and b. The output closes as soon as either input closes.
+func Zip[A, B any](p *Pipeline, a <-chan A, b <-chan B) <-chan Pair[A, B] {
+	n := p.newNode("zip", a, b)
+	out := output(p, n, make(chan Pair[A, B]))
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		for {
+			first, ok := recv(ctx, n, a)
+			if !ok {
+				return nil
+			}
+			second, ok := recv(ctx, n, b)
+			if !ok || !send(ctx, n, out, Pair[A, B]{first, second}) {
+				return nil
+			}
+		}
+	})
+	return out
+}
+
+// SlowConsumerPolicy decides what Broadcast does when a subscriber's buffer is full.
+type SlowConsumerPolicy int
+
+const (
+	// Block waits for the subscriber, holding up every other subscriber meanwhile.
+	Block SlowConsumerPolicy = iota
+	// DropNewest discards the item for this subscriber.
+	DropNewest
+	// DropOldest discards the oldest buffered item to make room for the new one, or the
+	// new one if the subscriber has no buffer.
+	DropOldest
+	// Disconnect closes the subscriber's channel and stops delivering to it.
+	Disconnect
+)
+
+// Broadcaster delivers every item of its input to all current subscribers.
+type Broadcaster[T any] struct {
+	p      *Pipeline
+	n      *node
+	mu     sync.Mutex
+	subs   map[*Subscription[T]]struct{}
+	closed bool // the input is done and every subscriber channel is closed
+}
+
+// Subscription is one subscriber of a Broadcaster.
+type Subscription[T any] struct {
+	b       *Broadcaster[T]
+	ch      chan T
+	policy  SlowConsumerPolicy
+	left    chan struct{} // closed by Unsubscribe
+	once    sync.Once
+	sending sync.Mutex // held by deliver, so Unsubscribe never closes ch during a send
+	mu      sync.Mutex
+	dropped int
+}
+
+// Broadcast starts delivering in to the subscribers of the returned Broadcaster.
+// Subscribers receive the items that arrive after they subscribe, and their channels are
+// closed when the input closes or the pipeline is cancelled.
+func Broadcast[T any](p *Pipeline, in <-chan T) *Broadcaster[T] {
+	b := &Broadcaster[T]{p: p, n: p.newNode("broadcast", in), subs: make(map[*Subscription[T]]struct{})}
+	p.Go(func(ctx context.Context) error {
+		defer b.closeAll()
+		for {
+			v, ok := recv(ctx, b.n, in)
+			if !ok {
+				return nil
+			}
+			for _, s := range b.snapshot() {
+				if !s.deliver(ctx, v) {
+					b.remove(s)
+				}
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+	})
+	return b
+}
+
+// Subscribe adds a subscriber with the given channel buffer and slow-consumer policy.
+// After the input is done it returns an already closed channel.
+func (b *Broadcaster[T]) Subscribe(buffer int, policy SlowConsumerPolicy) *Subscription[T] {
+	s := &Subscription[T]{b: b, ch: output(b.p, b.n, make(chan T, buffer)), policy: policy, left: make(chan struct{})}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.ch)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+func (b *Broadcaster[T]) snapshot() []*Subscription[T] {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := make([]*Subscription[T], 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	return subs
+}
+
+// remove closes the channel of a subscriber that unsubscribed or was disconnected. The
+// caller either is the broadcasting goroutine or holds s.sending, so a channel is never
+// closed during a send.
+func (b *Broadcaster[T]) remove(s *Subscription[T]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+func (b *Broadcaster[T]) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		close(s.ch)
+	}
+	b.subs = nil
+}
+
+// C returns the channel the subscriber receives items on.
+func (s *Subscription[T]) C() <-chan T {
+	return s.ch
+}
+
+// Dropped returns how many items were discarded for this subscriber.
+func (s *Subscription[T]) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Unsubscribe stops delivery to the subscriber and closes its channel before returning.
+// Items already buffered in it may still be received.
+func (s *Subscription[T]) Unsubscribe() {
+	s.once.Do(func() {
+		// a blocked deliver sees left closed and lets go of sending
+		close(s.left)
+		s.sending.Lock()
+		defer s.sending.Unlock()
+		s.b.remove(s)
+	})
+}
+
+// deliver hands v to the subscriber according to its policy and reports whether the
+// subscriber stays subscribed.
+func (s *Subscription[T]) deliver(ctx context.Context, v T) bool {
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	select {
+	case <-s.left:
+		return false
+	default:
+	}
+	select {
+	case s.ch <- v:
+		s.b.n.out.Add(1)
+		return true
+	default:
+	}
+
+	switch s.policy {
+	case Block:
+		start := time.Now()
+		defer func() { s.b.n.sendBlocked.Add(int64(time.Since(start))) }()
+		select {
+		case <-ctx.Done():
+			return true
+		case <-s.left:
+			return false
+		case <-s.b.n.gone:
+			return false
+		case s.ch <- v:
+			s.b.n.out.Add(1)
+			return true
+		}
+	case DropOldest:
+		if cap(s.ch) == 0 {
+			// nothing is buffered to make room with, so the new item is the one dropped
+			s.drop()
+			return true
+		}
+		// the broadcaster is the only sender, so once an item is taken there is room
+		select {
+		case <-s.ch:
+			s.drop()
+		default:
+		}
+		s.ch <- v
+		s.b.n.out.Add(1)
+		return true
+	case Disconnect:
+		return false
+	default: // DropNewest
+		s.drop()
+		return true
+	}
+}
+
+func (s *Subscription[T]) drop() {
+	s.mu.Lock()
+	s.dropped++
+	s.mu.Unlock()
+}
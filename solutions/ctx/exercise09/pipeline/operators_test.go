@@ -0,0 +1,314 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"exercise05"
+)
+
+// run applies stage to items and returns everything it emits.
+func run[In, Out any](t *testing.T, stage Stage[In, Out], items ...In) []Out {
+	t.Helper()
+	p := New(context.Background())
+	var got []Out
+	for v := range stage(p, From(p, items...)) {
+		got = append(got, v)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func isEven(v int) bool { return v%2 == 0 }
+
+func sum(acc, v int) int { return acc + v }
+
+func TestOperators(t *testing.T) {
+	items := []int{1, 2, 2, 3, 4, 4, 5}
+	tests := []struct {
+		name  string
+		stage Stage[int, int]
+		want  []int
+	}{
+		{"Filter", Filter(isEven), []int{2, 2, 4, 4}},
+		{"Scan", Scan(0, sum), []int{1, 3, 5, 8, 12, 16, 21}},
+		{"Reduce", Reduce(0, sum), []int{21}},
+		{"Take", Take[int](3), []int{1, 2, 2}},
+		{"Take more than available", Take[int](10), items},
+		{"Skip", Skip[int](4), []int{4, 4, 5}},
+		{"Distinct", Distinct(func(v int) int { return v }), []int{1, 2, 3, 4, 5}},
+		{"Throttle", Throttle[int](exercise05.NewTokenBucketWithLimit(exercise05.Inf)), items},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := run(t, tt.stage, items...); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReduce_EmptyInput(t *testing.T) {
+	if got := run(t, Reduce(10, sum)); !reflect.DeepEqual(got, []int{10}) {
+		t.Fatalf("got %v, want the initial value [10]", got)
+	}
+}
+
+// TestTake_StopsUpstream is a regression test: Take used to drain its input forever after
+// the first n items, so Wait never returned on an endless source.
+func TestTake_StopsUpstream(t *testing.T) {
+	before := runtime.NumGoroutine()
+	p := New(context.Background())
+	stage := Then(Then(Map(square), OrderedMap(2, square)), Take[int](3))
+	var got []int
+	for v := range stage(p, naturals(p)) {
+		got = append(got, v)
+	}
+	done := make(chan error, 1)
+	go func() { done <- p.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait did not return after Take had its items")
+	}
+	if want := []int{1, 16, 81}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	checkNoLeak(t, before)
+
+	// a branch that still reads the shared upstream is not cut short
+	p = New(context.Background())
+	outs := Tee(p, From(p, 1, 2, 3, 4, 5), 2)
+	first := Take[int](1)(p, outs[0])
+	var all []int
+	go func() {
+		for range first {
+		}
+	}()
+	for v := range outs[1] {
+		all = append(all, v)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(all, want) {
+		t.Fatalf("other branch got %v, want %v", all, want)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	p := New(context.Background())
+	in := make(chan int)
+	out := Debounce[int](30*time.Millisecond)(p, in)
+
+	// a burst of items closer than d collapses into its last item
+	for i := 1; i <= 3; i++ {
+		in <- i
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case v := <-out:
+		if v != 3 {
+			t.Fatalf("got %d, want 3", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no item after the burst settled")
+	}
+
+	in <- 4
+	close(in)
+	if v, ok := <-out; !ok || v != 4 {
+		t.Fatalf("got %d (open %v), want the pending 4 flushed on close", v, ok)
+	}
+	if _, ok := <-out; ok {
+		t.Fatal("expected the output to close")
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestThrottle_TokenBucket(t *testing.T) {
+	// burst 1 at 100/s: 6 items need 5 refills of 10ms each
+	tb := exercise05.NewTokenBucketWithBurst(100, 1)
+	start := time.Now()
+	got := run(t, Throttle[int](tb), 1, 2, 3, 4, 5, 6)
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Fatalf("6 items passed in %v, faster than the 100/s limit allows", elapsed)
+	}
+	if len(got) != 6 {
+		t.Fatalf("got %d items, want 6", len(got))
+	}
+}
+
+func TestTee(t *testing.T) {
+	p := New(context.Background())
+	outs := Tee(p, From(p, 1, 2, 3), 2)
+	results := make([][]int, 2)
+	done := make(chan struct{})
+	go func() {
+		for v := range outs[1] {
+			results[1] = append(results[1], v)
+		}
+		close(done)
+	}()
+	for v := range outs[0] {
+		results[0] = append(results[0], v)
+	}
+	<-done
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{1, 2, 3}
+	if !reflect.DeepEqual(results[0], want) || !reflect.DeepEqual(results[1], want) {
+		t.Fatalf("got %v, want both outputs %v", results, want)
+	}
+}
+
+func TestZip(t *testing.T) {
+	p := New(context.Background())
+	var got []Pair[int, string]
+	for pair := range Zip(p, From(p, 1, 2, 3), From(p, "a", "b")) {
+		got = append(got, pair)
+	}
+	p.Stop() // the first input still has an item nobody reads
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	want := []Pair[int, string]{{1, "a"}, {2, "b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// TestBroadcast_UnsubscribeQuiet is a regression test: an unsubscribed channel used to
+// close only when the next item was broadcast, so a reader hung on a quiet input.
+func TestBroadcast_UnsubscribeQuiet(t *testing.T) {
+	p := New(context.Background())
+	in := make(chan int)
+	b := Broadcast(p, in)
+	blocked := b.Subscribe(0, Block)
+	quiet := b.Subscribe(0, Block)
+	in <- 1 // the broadcaster is now blocked delivering to blocked
+	blocked.Unsubscribe()
+	quiet.Unsubscribe()
+
+	for _, s := range []*Subscription[int]{blocked, quiet} {
+		select {
+		case _, ok := <-s.C():
+			if ok {
+				t.Fatal("expected no items after Unsubscribe")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("channel not closed after Unsubscribe while the input is quiet")
+		}
+	}
+	close(in)
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	p := New(context.Background())
+	in := make(chan int)
+	b := Broadcast(p, in)
+
+	fast := b.Subscribe(10, Block)
+	newest := b.Subscribe(1, DropNewest)
+	oldest := b.Subscribe(1, DropOldest)
+	disconnect := b.Subscribe(1, Disconnect)
+	gone := b.Subscribe(1, Block)
+	gone.Unsubscribe()
+
+	// nobody reads the slow subscribers while three items are broadcast
+	for i := 1; i <= 3; i++ {
+		in <- i
+	}
+	close(in)
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	drain := func(s *Subscription[int]) []int {
+		var got []int
+		for v := range s.C() {
+			got = append(got, v)
+		}
+		return got
+	}
+	checks := []struct {
+		name    string
+		sub     *Subscription[int]
+		want    []int
+		dropped int
+	}{
+		{"block", fast, []int{1, 2, 3}, 0},
+		{"drop newest", newest, []int{1}, 2},
+		{"drop oldest", oldest, []int{3}, 2},
+		{"disconnect", disconnect, []int{1}, 0},
+		{"unsubscribed", gone, nil, 0},
+	}
+	for _, c := range checks {
+		if got := drain(c.sub); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+		if got := c.sub.Dropped(); got != c.dropped {
+			t.Errorf("%s: dropped %d, want %d", c.name, got, c.dropped)
+		}
+	}
+
+	if _, ok := <-b.Subscribe(1, Block).C(); ok {
+		t.Error("subscribing after the input closed should return a closed channel")
+	}
+}
+
+// TestOperators_NoLeak tests that every operator exits when the consumer stops early.
+func TestOperators_NoLeak(t *testing.T) {
+	tests := map[string]func(p *Pipeline) <-chan int{
+		"Filter":   func(p *Pipeline) <-chan int { return Filter(isEven)(p, naturals(p)) },
+		"Scan":     func(p *Pipeline) <-chan int { return Scan(0, sum)(p, naturals(p)) },
+		"Reduce":   func(p *Pipeline) <-chan int { return Reduce(0, sum)(p, naturals(p)) },
+		"Take":     func(p *Pipeline) <-chan int { return Take[int](2)(p, naturals(p)) },
+		"Skip":     func(p *Pipeline) <-chan int { return Skip[int](2)(p, naturals(p)) },
+		"Distinct": func(p *Pipeline) <-chan int { return Distinct(func(v int) int { return v % 5 })(p, naturals(p)) },
+		"Debounce": func(p *Pipeline) <-chan int { return Debounce[int](time.Millisecond)(p, naturals(p)) },
+		"Throttle": func(p *Pipeline) <-chan int {
+			return Throttle[int](exercise05.NewTokenBucketWithBurst(1000, 1))(p, naturals(p))
+		},
+		"Tee": func(p *Pipeline) <-chan int { return Tee(p, naturals(p), 2)[0] },
+		"Zip": func(p *Pipeline) <-chan int {
+			return Map(func(_ context.Context, pair Pair[int, int]) (int, error) { return pair.First, nil })(p, Zip(p, naturals(p), naturals(p)))
+		},
+		"Broadcast": func(p *Pipeline) <-chan int {
+			b := Broadcast(p, naturals(p))
+			b.Subscribe(0, Block)
+			return b.Subscribe(0, DropNewest).C()
+		},
+	}
+	for name, build := range tests {
+		t.Run(name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			p := New(context.Background())
+			out := build(p)
+			// read at most one item, then give up on the stream
+			select {
+			case <-out:
+			case <-time.After(10 * time.Millisecond):
+			}
+			p.Stop()
+			if err := p.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			checkNoLeak(t, before)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sequenced is an item tagged with its position in the input stream.
+type sequenced[T any] struct {
+	seq uint64
+	v   T
+}
+
+// OrderedMap returns a stage that applies fn on n workers concurrently but emits the
+// results in input order.
+//
+// Items are tagged with a sequence number and results are reassembled in a reorder
+// buffer. At most 2n items are in flight between being read and being emitted, so a slow
+// item stalls reading new input instead of letting the buffer grow without bound.
+func OrderedMap[In, Out any](n int, fn func(ctx context.Context, v In) (Out, error)) Stage[In, Out] {
+	if n < 1 {
+		n = 1
+	}
+	return func(p *Pipeline, in <-chan In) <-chan Out {
+		nd := p.newNode("orderedmap", in)
+		slots := make(chan struct{}, 2*n) // one per item in flight, freed once it is emitted
+		jobs := make(chan sequenced[In])
+		results := make(chan sequenced[Out])
+		out := output(p, nd, make(chan Out))
+		// the goroutines below hand items to each other, so they all stop with nd
+		ctx := nd.context(p.Context())
+
+		p.Go(func(context.Context) error {
+			defer close(jobs)
+			for seq := uint64(0); ; seq++ {
+				v, ok := recv(ctx, nd, in)
+				if !ok || !Send(ctx, slots, struct{}{}) || !Send(ctx, jobs, sequenced[In]{seq, v}) {
+					return nil
+				}
+			}
+		})
+
+		var workers sync.WaitGroup
+		for i := 0; i < n; i++ {
+			workers.Add(1)
+			p.Go(func(context.Context) error {
+				defer workers.Done()
+				for job := range jobs {
+					start := time.Now()
+					res, err := fn(ctx, job.v)
+					nd.observe(start)
+					if err != nil {
+						return err
+					}
+					if !Send(ctx, results, sequenced[Out]{job.seq, res}) {
+						return nil
+					}
+				}
+				return nil
+			})
+		}
+		p.Go(func(context.Context) error {
+			workers.Wait()
+			close(results)
+			return nil
+		})
+
+		p.Go(func(context.Context) error {
+			defer close(out)
+			pending := make(map[uint64]Out, 2*n)
+			var next uint64
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case r, ok := <-results:
+					if !ok {
+						return nil
+					}
+					pending[r.seq] = r.v
+					for v, ready := pending[next]; ready; v, ready = pending[next] {
+						if !send(ctx, nd, out, v) {
+							return nil
+						}
+						delete(pending, next)
+						next++
+						<-slots
+					}
+				}
+			}
+		})
+		return out
+	}
+}
@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOrderedMap_PreservesOrder(t *testing.T) {
+	before := runtime.NumGoroutine()
+	var running, peak atomic.Int64
+	jitter := OrderedMap(4, func(_ context.Context, v int) (int, error) {
+		cur := running.Add(1)
+		for p := peak.Load(); cur > p && !peak.CompareAndSwap(p, cur); p = peak.Load() {
+		}
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		running.Add(-1)
+		return v * v, nil
+	})
+
+	p := New(context.Background())
+	items := make([]int, 200)
+	for i := range items {
+		items[i] = i
+	}
+	i := 0
+	for v := range jitter(p, From(p, items...)) {
+		if v != i*i {
+			t.Fatalf("result %d = %d, want %d", i, v, i*i)
+		}
+		i++
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if i != 200 {
+		t.Fatalf("got %d results, want 200", i)
+	}
+	if peak.Load() < 2 {
+		t.Errorf("peak concurrency %d, want the items processed in parallel", peak.Load())
+	}
+	checkNoLeak(t, before)
+}
+
+func TestOrderedMap_BoundedBuffer(t *testing.T) {
+	const n = 2
+	release := make(chan struct{})
+	var started atomic.Int64
+	slowFirst := OrderedMap(n, func(_ context.Context, v int) (int, error) {
+		started.Add(1)
+		if v == 1 {
+			<-release
+		}
+		return v, nil
+	})
+
+	p := New(context.Background())
+	out := slowFirst(p, naturals(p))
+
+	// nothing can be emitted while the first item is stuck, so reading stops after 2n items
+	deadline := time.Now().Add(time.Second)
+	for started.Load() < 2*n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := started.Load(); got != 2*n {
+		t.Fatalf("%d items started while the first one was stuck, want the window of %d", got, 2*n)
+	}
+
+	close(release)
+	for want := 1; want <= 10; want++ {
+		if v := <-out; v != want {
+			t.Fatalf("got %d, want %d", v, want)
+		}
+	}
+	p.Stop()
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOrderedMap_Error(t *testing.T) {
+	before := runtime.NumGoroutine()
+	bad := errors.New("bad item")
+	p := New(context.Background())
+	out := OrderedMap(3, func(_ context.Context, v int) (int, error) {
+		if v == 7 {
+			return 0, bad
+		}
+		return v, nil
+	})(p, naturals(p))
+	for range out {
+	}
+	if err := p.Wait(); !errors.Is(err, bad) {
+		t.Fatalf("Wait = %v, want %v", err, bad)
+	}
+	checkNoLeak(t, before)
+}
+
+func TestOrderedMap_ConsumerStopsEarly(t *testing.T) {
+	before := runtime.NumGoroutine()
+	p := New(context.Background())
+	out := OrderedMap(4, square)(p, naturals(p))
+	for i := 1; i <= 3; i++ {
+		if v := <-out; v != i*i {
+			t.Fatalf("got %d, want %d", v, i*i)
+		}
+	}
+	p.Stop()
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	checkNoLeak(t, before)
+}
@@ -0,0 +1,200 @@
+package pipeline
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// PartitionOption configures Partition and NewPartitioner.
+type PartitionOption func(*partitionConfig)
+
+type partitionConfig struct {
+	replicas int // virtual nodes per partition on the hash ring, 0 for plain modulo
+}
+
+// WithConsistentHashing places partitions on a hash ring with replicas virtual nodes
+// each, so that changing the number of partitions moves only about 1/n of the keys
+// instead of nearly all of them. More replicas spread keys more evenly.
+func WithConsistentHashing(replicas int) PartitionOption {
+	return func(c *partitionConfig) {
+		if replicas < 1 {
+			replicas = 1
+		}
+		c.replicas = replicas
+	}
+}
+
+// Partitioned is the output of Partition.
+type Partitioned[T any] struct {
+	// Outs holds one output per partition. All items with the same key go to the same
+	// output, in input order.
+	Outs   []<-chan T
+	counts []atomic.Int64
+}
+
+// Counts returns how many items have been routed to each partition so far.
+func (pt *Partitioned[T]) Counts() []int64 {
+	counts := make([]int64, len(pt.counts))
+	for i := range pt.counts {
+		counts[i] = pt.counts[i].Load()
+	}
+	return counts
+}
+
+// Skew returns the item count of the busiest partition divided by the mean, so 1 means a
+// perfectly even spread and n means every item went to a single partition.
+func (pt *Partitioned[T]) Skew() float64 {
+	var total, busiest int64
+	for _, c := range pt.Counts() {
+		total += c
+		busiest = max(busiest, c)
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(busiest) * float64(len(pt.counts)) / float64(total)
+}
+
+// Partitioner assigns string keys to partitions, the same way Partition routes items.
+// Assignments depend only on the key, the partition count and the options, so they are
+// the same in every process. It is safe for concurrent use.
+type Partitioner struct {
+	n    int
+	cfg  partitionConfig
+	ring *hashRing // nil for plain modulo
+}
+
+// NewPartitioner returns a partitioner over n partitions.
+func NewPartitioner(n int, opts ...PartitionOption) *Partitioner {
+	var cfg partitionConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return newPartitioner(n, cfg)
+}
+
+func newPartitioner(n int, cfg partitionConfig) *Partitioner {
+	if n < 1 {
+		n = 1
+	}
+	pr := &Partitioner{n: n, cfg: cfg}
+	if cfg.replicas > 0 {
+		pr.ring = newHashRing(n, cfg.replicas)
+	}
+	return pr
+}
+
+// Partitions returns the number of partitions.
+func (pr *Partitioner) Partitions() int { return pr.n }
+
+// Locate returns the partition of key.
+func (pr *Partitioner) Locate(key string) int {
+	h := partitionHash(key)
+	if pr.ring != nil {
+		return pr.ring.locate(h)
+	}
+	return int(h % uint64(pr.n))
+}
+
+// Resize returns a partitioner with the same options over n partitions. With
+// WithConsistentHashing, going from n-1 to n partitions moves about 1/n of the keys, all
+// of them to the new partition; with plain modulo nearly every key moves. A running
+// Partition stage keeps its partition count, so compare the two partitioners to see which
+// keys change hands before restarting a pipeline with the new count.
+func (pr *Partitioner) Resize(n int) *Partitioner {
+	return newPartitioner(n, pr.cfg)
+}
+
+// Partition routes each item of in to one of n outputs by hashing the string key. Unlike
+// FanOut, where any free worker takes the next item, every key has a fixed partition, so
+// a worker per output sees each key's items in order. A partition that is not read holds
+// up all the others.
+func Partition[T any](p *Pipeline, in <-chan T, n int, key func(T) string, opts ...PartitionOption) *Partitioned[T] {
+	pr := NewPartitioner(n, opts...)
+	n = pr.Partitions()
+
+	nd := p.newNode("partition", in)
+	outs := make([]chan T, n)
+	pt := &Partitioned[T]{Outs: make([]<-chan T, n), counts: make([]atomic.Int64, n)}
+	for i := range outs {
+		outs[i] = output(p, nd, make(chan T))
+		pt.Outs[i] = outs[i]
+	}
+	p.Go(func(ctx context.Context) error {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+		for {
+			v, ok := recv(ctx, nd, in)
+			if !ok {
+				return nil
+			}
+			i := pr.Locate(key(v))
+			pt.counts[i].Add(1)
+			if !send(ctx, nd, outs[i], v) {
+				return nil
+			}
+		}
+	})
+	return pt
+}
+
+// hashRing maps hashes to partitions by placing replicas points per partition on a ring
+// and picking the first point at or after the hash.
+type hashRing struct {
+	points []uint64 // sorted
+	owners []int    // owners[i] is the partition of points[i]
+}
+
+func newHashRing(n, replicas int) *hashRing {
+	type point struct {
+		hash  uint64
+		owner int
+	}
+	all := make([]point, 0, n*replicas)
+	for i := 0; i < n; i++ {
+		for r := 0; r < replicas; r++ {
+			all = append(all, point{partitionHash(strconv.Itoa(i) + "#" + strconv.Itoa(r)), i})
+		}
+	}
+	sort.Slice(all, func(a, b int) bool { return all[a].hash < all[b].hash })
+
+	ring := &hashRing{points: make([]uint64, len(all)), owners: make([]int, len(all))}
+	for i, pt := range all {
+		ring.points[i], ring.owners[i] = pt.hash, pt.owner
+	}
+	return ring
+}
+
+func (r *hashRing) locate(h uint64) int {
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// partitionHash hashes key with 64-bit FNV-1a followed by the murmur3 finalizer, which
+// spreads keys that differ in a single byte over the whole ring. Unlike a randomly seeded
+// hash it is the same in every process, so a key keeps its partition across restarts and
+// machines.
+func partitionHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return fmix64(h.Sum64())
+}
+
+// fmix64 is the murmur3 finalizer: every input bit affects every output bit.
+func fmix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,158 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type event struct {
+	key string
+	seq int
+}
+
+// TestPartition_PerKeyOrder tests that each key always lands on one partition, in input order.
+func TestPartition_PerKeyOrder(t *testing.T) {
+	for name, opts := range map[string][]PartitionOption{
+		"modulo":     nil,
+		"consistent": {WithConsistentHashing(64)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			p := New(context.Background())
+			var events []event
+			for seq := 0; seq < 1000; seq++ {
+				events = append(events, event{fmt.Sprintf("k%d", seq%37), seq})
+			}
+			pt := Partition(p, From(p, events...), 4, func(e event) string { return e.key }, opts...)
+
+			var (
+				mu       sync.Mutex
+				owner    = map[string]int{}
+				last     = map[string]int{}
+				received int
+				wg       sync.WaitGroup
+			)
+			for i, out := range pt.Outs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for e := range out {
+						mu.Lock()
+						if o, ok := owner[e.key]; ok && o != i {
+							t.Errorf("key %s seen on partitions %d and %d", e.key, o, i)
+						}
+						owner[e.key] = i
+						if l, ok := last[e.key]; ok && l > e.seq {
+							t.Errorf("key %s out of order: %d after %d", e.key, e.seq, l)
+						}
+						last[e.key] = e.seq
+						received++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			if err := p.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			if received != 1000 {
+				t.Fatalf("received %d items, want 1000", received)
+			}
+
+			var total int64
+			for _, c := range pt.Counts() {
+				total += c
+			}
+			if total != 1000 {
+				t.Errorf("counts %v add up to %d, want 1000", pt.Counts(), total)
+			}
+			if skew := pt.Skew(); skew < 1 || skew > 2.5 {
+				t.Errorf("skew = %.2f for 37 keys over 4 partitions, want between 1 and 2.5", skew)
+			}
+			checkNoLeak(t, before)
+		})
+	}
+}
+
+func TestPartition_SkewHotKey(t *testing.T) {
+	p := New(context.Background())
+	items := make([]int, 100)
+	pt := Partition(p, From(p, items...), 4, func(v int) string { return strconv.Itoa(v) })
+	Sink(p, FanIn(p, pt.Outs...), func(context.Context, int) error { return nil })
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if skew := pt.Skew(); skew != 4 {
+		t.Fatalf("skew = %.2f with a single key over 4 partitions, want 4", skew)
+	}
+}
+
+// TestPartitioner_Stable is a regression test: keys used to be hashed with a random
+// per-process seed, so the same key landed on a different partition after a restart.
+func TestPartitioner_Stable(t *testing.T) {
+	// pinned values: changing them reshuffles every deployed partition assignment
+	modulo, ring := NewPartitioner(8), NewPartitioner(8, WithConsistentHashing(64))
+	for _, tt := range []struct {
+		key          string
+		modulo, ring int
+	}{
+		{"user-1", 5, 4},
+		{"user-2", 3, 0},
+		{"user-42", 5, 3},
+	} {
+		if got := modulo.Locate(tt.key); got != tt.modulo {
+			t.Errorf("modulo partition of %s = %d, want %d", tt.key, got, tt.modulo)
+		}
+		if got := ring.Locate(tt.key); got != tt.ring {
+			t.Errorf("ring partition of %s = %d, want %d", tt.key, got, tt.ring)
+		}
+	}
+}
+
+// TestPartitioner_Resize tests that with consistent hashing adding a partition moves only
+// a minority of keys, all of them to the new partition, while modulo moves most keys.
+func TestPartitioner_Resize(t *testing.T) {
+	const keys = 10000
+	ring := NewPartitioner(4, WithConsistentHashing(128))
+	grown := ring.Resize(5)
+	modulo := NewPartitioner(4)
+	if grown.Partitions() != 5 {
+		t.Fatalf("Resize(5) has %d partitions", grown.Partitions())
+	}
+	var movedRing, movedModulo int
+	for k := 0; k < keys; k++ {
+		key := "key-" + strconv.Itoa(k)
+		if from, to := ring.Locate(key), grown.Locate(key); from != to {
+			movedRing++
+			if to != 4 {
+				t.Fatalf("key %s moved from %d to %d, want moves only to the new partition", key, from, to)
+			}
+		}
+		if modulo.Locate(key) != modulo.Resize(5).Locate(key) {
+			movedModulo++
+		}
+	}
+	// the new partition should take about 1/5 of the keys
+	if frac := float64(movedRing) / keys; frac > 0.3 {
+		t.Errorf("consistent hashing moved %.0f%% of keys, want about 20%%", 100*frac)
+	}
+	if movedModulo < 2*movedRing {
+		t.Errorf("modulo moved %d keys, consistent hashing %d: expected a clear difference", movedModulo, movedRing)
+	}
+}
+
+func TestPartition_ConsumerStopsEarly(t *testing.T) {
+	before := runtime.NumGoroutine()
+	p := New(context.Background())
+	pt := Partition(p, naturals(p), 3, func(v int) string { return strconv.Itoa(v) })
+	<-FanIn(p, pt.Outs...)
+	p.Stop()
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	checkNoLeak(t, before)
+}
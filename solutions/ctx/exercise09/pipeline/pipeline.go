@@ -0,0 +1,234 @@
+// Package pipeline provides generic, context-aware building blocks for channel pipelines:
+// stages that transform a stream, fan-out to several workers, fan-in back to one stream
+// and sinks that consume the result.
+//
+// Every goroutine a stage starts is owned by a Pipeline. The first goroutine that fails
+// cancels the pipeline's context, every other stage observes the cancellation and exits,
+// and Wait reports that first error, in the style of errgroup.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errStopped is the cancellation cause used by Stop, so Wait can tell a deliberate early
+// stop from a failure.
+var errStopped = errors.New("pipeline stopped")
+
+// Pipeline owns the goroutines of a set of connected stages.
+type Pipeline struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+
+	errOnce sync.Once
+	err     error
+
+	graph graph
+}
+
+// New returns a pipeline whose stages stop when ctx is done.
+func New(ctx context.Context) *Pipeline {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &Pipeline{ctx: ctx, cancel: cancel}
+}
+
+// Context returns the context shared by all stages. It is cancelled by the first error,
+// by Stop, or when the parent context is done.
+func (p *Pipeline) Context() context.Context {
+	return p.ctx
+}
+
+// Go runs fn in a goroutine owned by the pipeline. A non-nil error cancels every stage.
+func (p *Pipeline) Go(fn func(ctx context.Context) error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if err := fn(p.ctx); err != nil {
+			p.fail(err)
+		}
+	}()
+}
+
+// fail records the first error and cancels the pipeline.
+func (p *Pipeline) fail(err error) {
+	p.errOnce.Do(func() {
+		p.err = err
+		p.cancel(err)
+	})
+}
+
+// Stop cancels all stages without recording an error. A consumer that stops reading
+// before the output is closed must call Stop (or cancel the parent context) and then
+// Wait, otherwise upstream goroutines stay blocked on their sends.
+func (p *Pipeline) Stop() {
+	p.cancel(errStopped)
+}
+
+// Wait blocks until every goroutine of the pipeline has exited. It returns the first
+// error returned by a stage, or the parent context's error if the pipeline was cancelled
+// from outside, or nil if it ran to completion or was stopped with Stop.
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	p.cancel(errStopped) // release the context's resources
+	if p.err != nil {
+		return p.err
+	}
+	if cause := context.Cause(p.ctx); !errors.Is(cause, errStopped) {
+		return cause
+	}
+	return nil
+}
+
+// Send delivers v on ch unless ctx is done first, and reports whether it was delivered.
+func Send[T any](ctx context.Context, ch chan<- T, v T) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case ch <- v:
+		return true
+	}
+}
+
+// Stage transforms an input stream into an output stream. Its goroutines are started on p
+// and must close the output once the input is closed or p's context is done.
+type Stage[In, Out any] func(p *Pipeline, in <-chan In) <-chan Out
+
+// invalidStage returns a stage of the given kind that fails the pipeline with err as soon
+// as it starts, for constructors given arguments they cannot run with.
+func invalidStage[In, Out any](kind string, err error) Stage[In, Out] {
+	return func(p *Pipeline, in <-chan In) <-chan Out {
+		n := p.newNode(kind, in)
+		out := output(p, n, make(chan Out))
+		p.Go(func(context.Context) error {
+			close(out)
+			return err
+		})
+		return out
+	}
+}
+
+// Then composes two stages into one.
+func Then[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return func(p *Pipeline, in <-chan A) <-chan C {
+		return second(p, first(p, in))
+	}
+}
+
+// From returns a stream that emits items in order and then closes.
+func From[T any](p *Pipeline, items ...T) <-chan T {
+	return Generate(p, func(ctx context.Context, emit func(T) bool) error {
+		for _, item := range items {
+			if !emit(item) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Generate returns a stream fed by fn. emit reports false once the pipeline is cancelled,
+// after which fn should return. An error from fn cancels the pipeline.
+func Generate[T any](p *Pipeline, fn func(ctx context.Context, emit func(T) bool) error) <-chan T {
+	n := p.newNode("generate")
+	out := output(p, n, make(chan T))
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		return fn(ctx, func(v T) bool { return send(ctx, n, out, v) })
+	})
+	return out
+}
+
+// Map returns a stage that applies fn to every item. An error from fn cancels the pipeline.
+func Map[In, Out any](fn func(ctx context.Context, v In) (Out, error)) Stage[In, Out] {
+	return func(p *Pipeline, in <-chan In) <-chan Out {
+		n := p.newNode("map", in)
+		out := output(p, n, make(chan Out))
+		p.Go(func(ctx context.Context) error {
+			defer close(out)
+			for {
+				v, ok := recv(ctx, n, in)
+				if !ok {
+					return nil
+				}
+				start := time.Now()
+				res, err := fn(ctx, v)
+				n.observe(start)
+				if err != nil {
+					return err
+				}
+				if !send(ctx, n, out, res) {
+					return nil
+				}
+			}
+		})
+		return out
+	}
+}
+
+// FanOut starts n copies of stage that all read from in, so each item is handled by
+// exactly one of them, and returns their outputs. Use FanIn to merge them again.
+func FanOut[In, Out any](p *Pipeline, in <-chan In, n int, stage Stage[In, Out]) []<-chan Out {
+	outs := make([]<-chan Out, n)
+	for i := range outs {
+		outs[i] = stage(p, in)
+	}
+	return outs
+}
+
+// FanIn merges several streams into one, in no particular order. The output closes once
+// every input is closed or the pipeline is cancelled.
+func FanIn[T any](p *Pipeline, ins ...<-chan T) <-chan T {
+	n := p.newNode("fanin", inputsOf(ins...)...)
+	out := output(p, n, make(chan T))
+	var wg sync.WaitGroup
+	for _, in := range ins {
+		wg.Add(1)
+		p.Go(func(ctx context.Context) error {
+			defer wg.Done()
+			for {
+				v, ok := recv(ctx, n, in)
+				if !ok || !send(ctx, n, out, v) {
+					return nil
+				}
+			}
+		})
+	}
+	p.Go(func(context.Context) error {
+		wg.Wait()
+		close(out)
+		return nil
+	})
+	return out
+}
+
+// Parallel returns a stage that runs n copies of stage concurrently and merges their
+// outputs. Output order is not preserved.
+func Parallel[In, Out any](n int, stage Stage[In, Out]) Stage[In, Out] {
+	return func(p *Pipeline, in <-chan In) <-chan Out {
+		return FanIn(p, FanOut(p, in, n, stage)...)
+	}
+}
+
+// Sink consumes in with fn on a goroutine owned by p. An error from fn cancels the
+// pipeline; call Wait to get it once the stream is drained.
+func Sink[T any](p *Pipeline, in <-chan T, fn func(ctx context.Context, v T) error) {
+	n := p.newNode("sink", in)
+	p.Go(func(ctx context.Context) error {
+		for {
+			v, ok := recv(ctx, n, in)
+			if !ok {
+				return nil
+			}
+			start := time.Now()
+			err := fn(ctx, v)
+			n.observe(start)
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
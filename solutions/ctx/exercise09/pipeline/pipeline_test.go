@@ -0,0 +1,158 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// checkNoLeak waits for the goroutine count to fall back to before.
+func checkNoLeak(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("goroutines leaked: %d > %d\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// naturals emits 1, 2, 3, ... until the pipeline is cancelled.
+func naturals(p *Pipeline) <-chan int {
+	return Generate(p, func(ctx context.Context, emit func(int) bool) error {
+		for i := 1; emit(i); i++ {
+		}
+		return nil
+	})
+}
+
+func square(_ context.Context, v int) (int, error) { return v * v, nil }
+
+func TestParallelMap(t *testing.T) {
+	before := runtime.NumGoroutine()
+	p := New(context.Background())
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i + 1
+	}
+	out := Parallel(4, Map(square))(p, From(p, items...))
+
+	var got []int
+	for v := range out {
+		got = append(got, v)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(got)
+	if len(got) != 100 {
+		t.Fatalf("got %d items, want 100", len(got))
+	}
+	for i, v := range got {
+		if want := (i + 1) * (i + 1); v != want {
+			t.Fatalf("got[%d] = %d, want %d", i, v, want)
+		}
+	}
+	checkNoLeak(t, before)
+}
+
+func TestThen(t *testing.T) {
+	p := New(context.Background())
+	toString := Map(func(_ context.Context, v int) (string, error) { return string(rune('a' + v)), nil })
+	out := Then(Map(square), toString)(p, From(p, 0, 1, 2, 3))
+
+	var got string
+	Sink(p, out, func(_ context.Context, s string) error {
+		got += s
+		return nil
+	})
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if got != "abej" {
+		t.Fatalf("got %q, want %q", got, "abej")
+	}
+}
+
+func TestConsumerStopsEarly(t *testing.T) {
+	before := runtime.NumGoroutine()
+	p := New(context.Background())
+	outs := FanOut(p, naturals(p), 3, Map(square))
+	merged := FanIn(p, outs...)
+
+	for i := 0; i < 5; i++ {
+		<-merged
+	}
+	p.Stop()
+	if err := p.Wait(); err != nil {
+		t.Fatalf("Wait after Stop: %v, want nil", err)
+	}
+	checkNoLeak(t, before)
+}
+
+func TestFirstErrorCancelsAll(t *testing.T) {
+	before := runtime.NumGoroutine()
+	boom := errors.New("boom")
+	var sunk atomic.Int64
+
+	p := New(context.Background())
+	failing := Map(func(_ context.Context, v int) (int, error) {
+		if v == 50 {
+			return 0, boom
+		}
+		return v, nil
+	})
+	out := Parallel(4, failing)(p, naturals(p))
+	Sink(p, out, func(context.Context, int) error {
+		sunk.Add(1)
+		return nil
+	})
+
+	if err := p.Wait(); !errors.Is(err, boom) {
+		t.Fatalf("Wait = %v, want %v", err, boom)
+	}
+	if p.Context().Err() == nil {
+		t.Error("the pipeline context should be cancelled by the error")
+	}
+	if n := sunk.Load(); n == 0 {
+		t.Error("expected values before the failure to reach the sink")
+	}
+	checkNoLeak(t, before)
+}
+
+func TestSinkError(t *testing.T) {
+	before := runtime.NumGoroutine()
+	full := errors.New("disk full")
+	p := New(context.Background())
+	Sink(p, naturals(p), func(_ context.Context, v int) error {
+		if v == 3 {
+			return full
+		}
+		return nil
+	})
+	if err := p.Wait(); !errors.Is(err, full) {
+		t.Fatalf("Wait = %v, want %v", err, full)
+	}
+	checkNoLeak(t, before)
+}
+
+func TestParentCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	p := New(ctx)
+	out := Map(square)(p, naturals(p))
+	<-out
+	cancel()
+	for range out {
+	}
+	if err := p.Wait(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait = %v, want context.Canceled", err)
+	}
+	checkNoLeak(t, before)
+}
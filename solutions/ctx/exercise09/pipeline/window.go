@@ -0,0 +1,312 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// cancelFlushTimeout is how long a windowing stage without an OnCancel hook offers its
+// partial window downstream after the pipeline is cancelled.
+const cancelFlushTimeout = 100 * time.Millisecond
+
+// WindowOption configures a windowing or batching stage.
+type WindowOption[T any] func(*windowConfig[T])
+
+type windowConfig[T any] struct {
+	onCancel func(partial []T)
+}
+
+// OnCancel sets a hook that receives the partial window left when the pipeline is
+// cancelled, instead of offering it downstream where only a reader outside the pipeline
+// can still take it. This is the place to, for example, write the last database batch on
+// shutdown.
+func OnCancel[T any](fn func(partial []T)) WindowOption[T] {
+	return func(c *windowConfig[T]) { c.onCancel = fn }
+}
+
+// windower is the state of one windowing stage. emit sends a window downstream and
+// reports false once the pipeline is cancelled; a windower keeps a window it failed to
+// emit so that rest can hand it to the OnCancel hook.
+type windower[T any] interface {
+	// add records an item and emits any window it completes.
+	add(v T, emit func([]T) bool) bool
+	// timer returns a channel that fires when a time-based window is due, or nil.
+	timer() <-chan time.Time
+	// expire emits the window that is due when timer fires.
+	expire(emit func([]T) bool) bool
+	// rest stops any timer and returns the items not yet emitted.
+	rest() []T
+}
+
+// windowStage runs a windower over the input as a stage of the given kind. When the
+// input closes the partial window is sent downstream. When the pipeline is cancelled it
+// goes to the OnCancel hook if there is one; otherwise it is offered downstream for up to
+// cancelFlushTimeout, which reaches a consumer outside the pipeline that reads the output
+// until it closes. Stages of the pipeline stop on cancellation themselves, so a partial
+// window is not offered to them.
+func windowStage[T any](kind string, opts []WindowOption[T], newWindower func() windower[T]) Stage[T, []T] {
+	var cfg windowConfig[T]
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(p *Pipeline, in <-chan T) <-chan []T {
+		n := p.newNode(kind, in)
+		out := output(p, n, make(chan []T))
+		p.Go(func(ctx context.Context) error {
+			defer close(out)
+			w := newWindower()
+			emit := func(window []T) bool { return send(ctx, n, out, window) }
+			cancelled := func() error {
+				partial := w.rest()
+				switch {
+				case len(partial) == 0:
+				case cfg.onCancel != nil:
+					cfg.onCancel(partial)
+				case !p.readByStages((<-chan []T)(out)):
+					flush(n, out, partial)
+				}
+				return nil
+			}
+			for {
+				waiting := time.Now()
+				select {
+				case <-ctx.Done():
+					return cancelled()
+				case <-n.gone:
+					return cancelled()
+				case <-w.timer():
+					n.waited(waiting)
+					if !w.expire(emit) {
+						return cancelled()
+					}
+				case v, ok := <-in:
+					n.waited(waiting)
+					if !ok {
+						if partial := w.rest(); len(partial) > 0 && !emit(partial) {
+							return cancelled()
+						}
+						return nil
+					}
+					n.receivedFrom(in)
+					if !w.add(v, emit) {
+						return cancelled()
+					}
+				}
+			}
+		})
+		return out
+	}
+}
+
+// flush offers the partial window left at cancellation to a reader outside the
+// pipeline, giving up after cancelFlushTimeout or once n is stopped.
+func flush[T any](n *node, out chan<- []T, partial []T) {
+	t := time.NewTimer(cancelFlushTimeout)
+	defer t.Stop()
+	select {
+	case out <- partial:
+		n.out.Add(1)
+	case <-n.gone:
+	case <-t.C:
+	}
+}
+
+// CountWindow returns a stage that groups items into windows of size items, starting a
+// new window every step items. step == size gives tumbling windows, step < size
+// overlapping sliding windows, and step > size skips step-size items between windows.
+// When the input closes, items not yet emitted are emitted as a final partial window.
+func CountWindow[T any](size, step int, opts ...WindowOption[T]) Stage[T, []T] {
+	if size < 1 {
+		size = 1
+	}
+	if step < 1 {
+		step = size
+	}
+	return windowStage("countwindow", opts, func() windower[T] { return &countWindow[T]{size: size, step: step} })
+}
+
+type countWindow[T any] struct {
+	size, step int
+	buf        []T
+	fresh      int // items in buf that have not been part of an emitted window
+	skip       int // items still to be dropped between hopping windows
+}
+
+func (w *countWindow[T]) add(v T, emit func([]T) bool) bool {
+	if w.skip > 0 {
+		w.skip--
+		return true
+	}
+	w.buf = append(w.buf, v)
+	w.fresh++
+	if len(w.buf) < w.size {
+		return true
+	}
+	if !emit(append([]T(nil), w.buf...)) {
+		return false
+	}
+	w.fresh = 0
+	if w.step >= w.size {
+		w.buf, w.skip = w.buf[:0], w.step-w.size
+	} else {
+		w.buf = append(w.buf[:0], w.buf[w.step:]...)
+	}
+	return true
+}
+
+func (w *countWindow[T]) timer() <-chan time.Time { return nil }
+
+func (w *countWindow[T]) expire(func([]T) bool) bool { return true }
+
+func (w *countWindow[T]) rest() []T {
+	if w.fresh == 0 {
+		return nil
+	}
+	return w.buf
+}
+
+// TimeWindow returns a stage that emits, every step, the items that arrived during the
+// last size. step == size gives tumbling windows and step < size sliding ones. Empty
+// windows are not emitted. When the input closes, the items that arrived since the last
+// window was due are emitted as a final partial window. A size that is not positive
+// fails the pipeline when the stage starts.
+func TimeWindow[T any](size, step time.Duration, opts ...WindowOption[T]) Stage[T, []T] {
+	if size <= 0 {
+		return invalidStage[T, []T]("timewindow", fmt.Errorf("timewindow: size must be positive, got %v", size))
+	}
+	if step <= 0 {
+		step = size
+	}
+	return windowStage("timewindow", opts, func() windower[T] {
+		return &timeWindow[T]{size: size, step: step, ticker: time.NewTicker(step), last: time.Now()}
+	})
+}
+
+// stamped is an item with its arrival time.
+type stamped[T any] struct {
+	at time.Time
+	v  T
+}
+
+type timeWindow[T any] struct {
+	size, step time.Duration
+	ticker     *time.Ticker
+	last       time.Time // when the previous window ended
+	buf        []stamped[T]
+	pending    []T // a due window that could not be emitted
+}
+
+// since returns the items that arrived after t.
+func (w *timeWindow[T]) since(t time.Time) []T {
+	var items []T
+	for _, s := range w.buf {
+		if s.at.After(t) {
+			items = append(items, s.v)
+		}
+	}
+	return items
+}
+
+func (w *timeWindow[T]) add(v T, _ func([]T) bool) bool {
+	w.buf = append(w.buf, stamped[T]{time.Now(), v})
+	return true
+}
+
+func (w *timeWindow[T]) timer() <-chan time.Time { return w.ticker.C }
+
+func (w *timeWindow[T]) expire(emit func([]T) bool) bool {
+	// the window starts where the previous one ended plus step minus size, so timer
+	// jitter neither drops nor repeats items in tumbling windows
+	now := time.Now()
+	if items := w.since(w.last.Add(w.step - w.size)); len(items) > 0 && !emit(items) {
+		w.pending = items
+		return false
+	}
+	w.last = now
+	// drop the items that cannot be part of the next window
+	keep := now.Add(w.step - w.size)
+	i := 0
+	for i < len(w.buf) && !w.buf[i].at.After(keep) {
+		i++
+	}
+	w.buf = append(w.buf[:0], w.buf[i:]...)
+	return true
+}
+
+func (w *timeWindow[T]) rest() []T {
+	w.ticker.Stop()
+	if w.pending != nil {
+		return w.pending
+	}
+	return w.since(w.last)
+}
+
+// SessionWindow returns a stage that groups items into sessions separated by at least gap
+// without input. A session is emitted once gap has passed since its last item, or when
+// the input closes.
+func SessionWindow[T any](gap time.Duration, opts ...WindowOption[T]) Stage[T, []T] {
+	return windowStage("sessionwindow", opts, func() windower[T] { return &timedBatch[T]{timeout: gap, resetOnAdd: true} })
+}
+
+// Batch returns a stage that groups items into batches of up to size items. A batch is
+// flushed as soon as it is full or timeout after its first item arrived, whichever comes
+// first, and a partial batch is flushed when the input closes.
+func Batch[T any](size int, timeout time.Duration, opts ...WindowOption[T]) Stage[T, []T] {
+	if size < 1 {
+		size = 1
+	}
+	return windowStage("batch", opts, func() windower[T] { return &timedBatch[T]{size: size, timeout: timeout} })
+}
+
+// timedBatch collects items until the batch is full (if size > 0) or its timer fires. The
+// timer starts with the first item, or restarts with every item if resetOnAdd is set,
+// which turns it into an inactivity gap.
+type timedBatch[T any] struct {
+	size       int
+	timeout    time.Duration
+	resetOnAdd bool
+	buf        []T
+	t          *time.Timer
+}
+
+func (w *timedBatch[T]) add(v T, emit func([]T) bool) bool {
+	if w.t != nil && w.resetOnAdd {
+		w.t.Stop()
+		w.t = nil
+	}
+	if w.t == nil {
+		w.t = time.NewTimer(w.timeout)
+	}
+	w.buf = append(w.buf, v)
+	if w.size > 0 && len(w.buf) >= w.size {
+		return w.expire(emit)
+	}
+	return true
+}
+
+func (w *timedBatch[T]) timer() <-chan time.Time {
+	if w.t == nil {
+		return nil
+	}
+	return w.t.C
+}
+
+func (w *timedBatch[T]) expire(emit func([]T) bool) bool {
+	if w.t != nil {
+		w.t.Stop()
+		w.t = nil
+	}
+	if !emit(w.buf) {
+		return false
+	}
+	w.buf = nil
+	return true
+}
+
+func (w *timedBatch[T]) rest() []T {
+	if w.t != nil {
+		w.t.Stop()
+	}
+	return w.buf
+}
@@ -0,0 +1,249 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collect runs stage over items and returns every window it emits.
+func collect[T any](t *testing.T, stage Stage[T, []T], items ...T) [][]T {
+	t.Helper()
+	p := New(context.Background())
+	var got [][]T
+	for w := range stage(p, From(p, items...)) {
+		got = append(got, w)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+// receive returns the next window from out or fails after timeout.
+func receive[T any](t *testing.T, out <-chan []T, timeout time.Duration) []T {
+	t.Helper()
+	select {
+	case w, ok := <-out:
+		if !ok {
+			t.Fatal("output closed unexpectedly")
+		}
+		return w
+	case <-time.After(timeout):
+		t.Fatalf("no window within %v", timeout)
+		return nil
+	}
+}
+
+func TestCountWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		size, step int
+		want       [][]int
+	}{
+		{"tumbling", 3, 3, [][]int{{1, 2, 3}, {4, 5, 6}, {7}}},
+		{"sliding", 3, 1, [][]int{{1, 2, 3}, {2, 3, 4}, {3, 4, 5}, {4, 5, 6}, {5, 6, 7}}},
+		{"sliding with partial", 3, 2, [][]int{{1, 2, 3}, {3, 4, 5}, {5, 6, 7}}},
+		{"hopping", 2, 3, [][]int{{1, 2}, {4, 5}, {7}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collect(t, CountWindow[int](tt.size, tt.step), 1, 2, 3, 4, 5, 6, 7)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatch_Size(t *testing.T) {
+	got := collect(t, Batch[int](2, time.Hour), 1, 2, 3, 4, 5)
+	if want := [][]int{{1, 2}, {3, 4}, {5}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestBatch_Timeout(t *testing.T) {
+	p := New(context.Background())
+	in := make(chan int)
+	out := Batch[int](100, 20*time.Millisecond)(p, in)
+
+	start := time.Now()
+	in <- 1
+	in <- 2
+	if w := receive(t, out, time.Second); !reflect.DeepEqual(w, []int{1, 2}) {
+		t.Fatalf("got %v, want [1 2]", w)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("batch flushed after %v, before the 20ms timeout", elapsed)
+	}
+
+	in <- 3
+	close(in)
+	if w := receive(t, out, time.Second); !reflect.DeepEqual(w, []int{3}) {
+		t.Fatalf("got %v, want the partial batch [3]", w)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionWindow(t *testing.T) {
+	p := New(context.Background())
+	in := make(chan string)
+	out := SessionWindow[string](30*time.Millisecond)(p, in)
+
+	// items closer together than the gap belong to the same session
+	for _, v := range []string{"a", "b", "c"} {
+		in <- v
+		time.Sleep(5 * time.Millisecond)
+	}
+	if w := receive(t, out, time.Second); !reflect.DeepEqual(w, []string{"a", "b", "c"}) {
+		t.Fatalf("got %v, want [a b c]", w)
+	}
+
+	in <- "d"
+	close(in)
+	if w := receive(t, out, time.Second); !reflect.DeepEqual(w, []string{"d"}) {
+		t.Fatalf("got %v, want the partial session [d]", w)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeWindow(t *testing.T) {
+	p := New(context.Background())
+	in := make(chan int)
+	out := TimeWindow[int](50*time.Millisecond, 50*time.Millisecond)(p, in)
+
+	in <- 1
+	in <- 2
+	if w := receive(t, out, time.Second); !reflect.DeepEqual(w, []int{1, 2}) {
+		t.Fatalf("got %v, want [1 2]", w)
+	}
+	// the next window only holds items that arrived after the previous one was emitted
+	in <- 3
+	close(in)
+	if w := receive(t, out, time.Second); !reflect.DeepEqual(w, []int{3}) {
+		t.Fatalf("got %v, want the partial window [3]", w)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeWindow_Sliding(t *testing.T) {
+	p := New(context.Background())
+	in := make(chan int)
+	out := TimeWindow[int](time.Hour, 20*time.Millisecond)(p, in)
+
+	in <- 1
+	first := receive(t, out, time.Second)
+	in <- 2
+	// with a window longer than the step, item 1 is still part of the next window
+	var second []int
+	for len(second) < 2 {
+		second = receive(t, out, time.Second)
+	}
+	p.Stop()
+	for range out {
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, []int{1}) || !reflect.DeepEqual(second, []int{1, 2}) {
+		t.Fatalf("got %v then %v, want [1] then [1 2]", first, second)
+	}
+}
+
+// TestWindow_OnCancel tests that every windowing stage hands its partial window to the
+// OnCancel hook and leaks no goroutine when the pipeline is cancelled.
+func TestWindow_OnCancel(t *testing.T) {
+	stages := map[string]func(hook WindowOption[int]) Stage[int, []int]{
+		"count":   func(h WindowOption[int]) Stage[int, []int] { return CountWindow(10, 10, h) },
+		"time":    func(h WindowOption[int]) Stage[int, []int] { return TimeWindow(time.Hour, time.Hour, h) },
+		"session": func(h WindowOption[int]) Stage[int, []int] { return SessionWindow(time.Hour, h) },
+		"batch":   func(h WindowOption[int]) Stage[int, []int] { return Batch(10, time.Hour, h) },
+	}
+	for name, stage := range stages {
+		t.Run(name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			var (
+				mu      sync.Mutex
+				partial []int
+			)
+			hook := OnCancel(func(w []int) {
+				mu.Lock()
+				partial = w
+				mu.Unlock()
+			})
+
+			p := New(context.Background())
+			in := make(chan int)
+			out := stage(hook)(p, in)
+			in <- 1
+			in <- 2
+			p.Stop()
+			for range out {
+			}
+			if err := p.Wait(); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(partial, []int{1, 2}) {
+				t.Fatalf("OnCancel got %v, want [1 2]", partial)
+			}
+			checkNoLeak(t, before)
+		})
+	}
+}
+
+// TestWindow_FlushOnCancel tests that without an OnCancel hook the partial window is
+// delivered to a reader that keeps reading after the pipeline is cancelled.
+func TestWindow_FlushOnCancel(t *testing.T) {
+	stages := map[string]Stage[int, []int]{
+		"count":   CountWindow[int](10, 10),
+		"time":    TimeWindow[int](time.Hour, time.Hour),
+		"session": SessionWindow[int](time.Hour),
+		"batch":   Batch[int](10, time.Hour),
+	}
+	for name, stage := range stages {
+		t.Run(name, func(t *testing.T) {
+			p := New(context.Background())
+			in := make(chan int)
+			out := stage(p, in)
+			in <- 1
+			in <- 2
+			p.Stop()
+			var got [][]int
+			for w := range out {
+				got = append(got, w)
+			}
+			if err := p.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, [][]int{{1, 2}}) {
+				t.Fatalf("got %v, want the partial window [[1 2]]", got)
+			}
+		})
+	}
+}
+
+// TestTimeWindow_InvalidSize is a regression test: a non-positive size used to reach
+// time.NewTicker and panic inside the stage's goroutine.
+func TestTimeWindow_InvalidSize(t *testing.T) {
+	p := New(context.Background())
+	out := TimeWindow[int](0, 0)(p, From(p, 1, 2, 3))
+	for range out {
+	}
+	if err := p.Wait(); err == nil || !strings.Contains(err.Error(), "size must be positive") {
+		t.Fatalf("expected an invalid size error, got %v", err)
+	}
+}